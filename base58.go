@@ -23,7 +23,8 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
+	// 每个前导的0x00字节编码为一个BASE58[0]
+	for _, b := range input {
 		if b == 0x00 {
 			result = append([]byte{BASE58[0]}, result...)
 		} else {
@@ -39,10 +40,11 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != BASE58[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
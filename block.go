@@ -3,75 +3,124 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"log"
-	"time"
 )
 
-type Block struct {
-	Timestamp     int64 // 创建区块的当前事件戳
-	Transactions  []*Transaction
+const blockVersion = 1
+
+// BlockHeader 区块头，工作量证明只对区块头计算hash
+type BlockHeader struct {
+	Version       int
 	PrevBlockHash []byte // 上一个区块的hash
-	Hash          []byte
-	Nonce         int // 工作量证明产生的随机值
+	MerkleRoot    []byte // 区块中交易的默克尔树根
+	Timestamp     int64  // 创建区块的当前事件戳
+	Bits          uint32 // 紧凑格式的难度target
+	Nonce         int    // 工作量证明产生的随机值
+	Height        int    // 区块高度，创世区块为0
 }
 
-// HashTransactions返回块中Transactions的哈希值
-// 使用交易的ID计算hash
+// Block 区块，由区块头与交易组成
+type Block struct {
+	BlockHeader
+	Transactions []*Transaction
+	Hash         []byte
+
+	// PoA区块签名者的公钥与对区块hash的签名，PoW区块为空
+	SignerPubKey []byte
+	Signature    []byte
+}
+
+// HashTransactions 返回块中Transactions的默克尔树根
+// 叶子节点为交易的ID
 func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+	return b.merkleTree().RootNode.Data
+}
+
+// MerkleProof 生成ID对应交易在区块中的默克尔包含证明
+func (b *Block) MerkleProof(ID []byte) (*MerkleProof, error) {
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, ID) {
+			return b.merkleTree().Proof(i), nil
+		}
+	}
+
+	return nil, errors.New("Transaction is not found in block")
+}
+
+// merkleTree 使用区块中交易的ID构建默克尔树
+func (b *Block) merkleTree() *MerkleTree {
+	var txIDs [][]byte
 
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		txIDs = append(txIDs, tx.ID)
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
 
-	return txHash[:]
+	return NewMerkleTree(txIDs)
 }
 
-// Serialize 序列化区块
+// Serialize 返回规范二进制格式的区块，不包含区块hash
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-	err := encoder.Encode(b)
-	if err != nil {
-		log.Panic(err)
-	}
-	return result.Bytes()
+	var w canonicalWriter
+	b.encode(&w)
+
+	return w.buf.Bytes()
 }
 
-// DeserializeBlock 反序列化区块
+// DeserializeBlock 反序列化区块并计算区块hash
 func DeserializeBlock(d []byte) *Block {
-	var block Block
-
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	block, err := decodeBlock(d)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return &block
+	return block
+}
+
+// CalcHash 计算区块头的hash
+func (b *Block) CalcHash() []byte {
+	hash := sha256.Sum256(NewProofOfWork(b).prepareData(b.Nonce))
+	return hash[:]
 }
 
-// NewBlock 创建区块
-func NewBlock(transactions []*Transaction, prevBlockHash []byte) *Block {
+// NewBlock 创建区块，区块需要通过共识引擎的Seal完成封装之后才有效
+// height 为区块高度，bits 为区块使用的难度，timestamp 为区块的时间戳
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, timestamp int64) *Block {
 	block := &Block{
-		Timestamp:     time.Now().Unix(),
-		Transactions:  transactions,
-		PrevBlockHash: prevBlockHash,
-		Hash:          []byte{},
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     timestamp,
+			Bits:          bits,
+			Height:        height,
+		},
+		Transactions: transactions,
+		Hash:         []byte{},
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
+
+// NewGenesisBlock 创建网络params的创世区块，区块的内容完全由params决定
+// coinbase交易的输出锁定脚本为OP_RETURN，无法使用；创世区块不需要共识引擎封装，由GenesisHash识别
+func NewGenesisBlock(params *ChainParams) *Block {
+	coinbase := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{}, Vout: -1, ScriptSig: []byte(params.GenesisCoinbaseData)}},
+		Vout:    []TXOutput{{Value: params.InitialSubsidy, ScriptPubKey: []byte{OP_RETURN}}},
 	}
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
+	coinbase.ID = coinbase.CalcID()
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	block := NewBlock([]*Transaction{coinbase}, []byte{}, 0, params.PowLimitBits, params.GenesisTimestamp)
+	block.Nonce = params.GenesisNonce
+	block.Hash = block.CalcHash()
 
 	return block
 }
 
-// NewGenesisBlock 创建创世区块
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{})
+// isGenesisHash 判断hash是否为当前网络的创世区块hash
+func isGenesisHash(hash []byte) bool {
+	return hex.EncodeToString(hash) == activeNet.GenesisHash
 }
@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
 
 	"github.com/boltdb/bolt"
 )
 
 const (
-	dbFile              = "block.db"
-	blocksBucket        = "blocks"
-	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+	dbFile          = "block.db"
+	blocksBucket    = "blocks"
+	chainworkBucket = "chainwork" // 区块hash -> 从创世区块到该区块的累计工作量
+	heightsBucket   = "heights"   // 主链上区块的高度 -> 区块hash
+	genesisKey      = "genesis"   // meta bucket中记录链的创世区块hash的key
 )
 
 type Blockchain struct {
@@ -23,19 +27,35 @@ type Blockchain struct {
 	db  *bolt.DB
 }
 
-// MineBlock 使用提供的交易挖掘一个新区块
-func (bc *Blockchain) MineBlock(transactions []*Transaction) {
-	var lastHash []byte
+// MineBlock 使用提供的交易挖掘一个新区块并加入链中，区块由activeEngine封装，ctx被取消时停止挖矿并返回ctx.Err()
+// 区块与从其他节点收到的区块一样通过AddBlock验证，区块与chainstate在同一个bolt事务中写入，验证失败时返回错误
+func (bc *Blockchain) MineBlock(ctx context.Context, minerAddress string, transactions []*Transaction) (*Block, error) {
+	newBlock := bc.NewBlockTemplate(minerAddress, transactions)
 
-	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
-		}
+	err := activeEngine.Seal(ctx, newBlock)
+	if err != nil {
+		return nil, err
 	}
 
+	err = bc.AddBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+// NewBlockTemplate 使用提供的交易创建以当前tip为父区块、尚未封装的区块
+// 交易按提供的顺序加入区块，加入之后区块大小会超过maxBlockSize的交易不加入区块
+// 验证失败的交易(例如链重组之后输入已经被使用)不加入区块
+// 区块的第一个交易为支付给minerAddress的coinbase，金额为挖矿奖励加上区块中所有交易的手续费
+func (bc *Blockchain) NewBlockTemplate(minerAddress string, transactions []*Transaction) *Block {
+	var lastHash []byte
+
 	err := bc.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		// bolt返回的数据只在事务内有效，需要复制
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
 		return nil
 	})
@@ -44,64 +64,343 @@ func (bc *Blockchain) MineBlock(transactions []*Transaction) {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash)
+	height := bc.GetBestHeight() + 1
+	bits := activeEngine.CalcDifficulty(bc, lastHash)
+
+	// 时间戳必须大于过去区块时间的中位数，出块很快时使用中位数加1
+	timestamp := clock()
+	if medianTime := bc.MedianTimePast(lastHash); timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+
+	newBlock := func(included []*Transaction, fees int) *Block {
+		cbTx := NewCoinbaseTX(minerAddress, "", height, fees)
+		return NewBlock(append([]*Transaction{cbTx}, included...), lastHash, height, bits, timestamp)
+	}
+
+	fees := 0
+	size := len(newBlock(nil, 0).Serialize())
+	var included []*Transaction
+	for _, tx := range transactions {
+		if !bc.VerifyTransaction(tx) {
+			log.Printf("Skipped invalid transaction %x\n", tx.ID)
+			continue
+		}
+		txSize := len(tx.Serialize())
+		if size+txSize > maxBlockSize {
+			continue
+		}
+		size += txSize
+		fees += bc.TransactionFee(tx)
+		included = append(included, tx)
+	}
+
+	// 交易数与coinbase金额的编码可能变长，区块仍然超过上限时移除最后加入的交易
+	block := newBlock(included, fees)
+	for len(included) > 0 && len(block.Serialize()) > maxBlockSize {
+		fees -= bc.TransactionFee(included[len(included)-1])
+		included = included[:len(included)-1]
+		block = newBlock(included, fees)
+	}
+
+	return block
+}
+
+// AddBlock 验证区块并保存到链中，本地挖出的区块与从其他节点收到的区块都通过这里加入链
+// 父区块必须已经在db中；区块所在分支的累计工作量超过当前主链时，切换到该分支
+func (bc *Blockchain) AddBlock(block *Block) error {
+	if bc.HasBlock(block.Hash) {
+		return nil
+	}
+
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	return bc.storeBlock(block)
+}
 
-	err = bc.db.Update(func(tx *bolt.Tx) error {
+// storeBlock 保存区块及其累计工作量
+// 父区块为tip时直接连接到主链；区块所在分支累计工作量超过主链时进行重组；否则作为侧链保存
+func (bc *Blockchain) storeBlock(block *Block) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
+		wb, err := tx.CreateBucketIfNotExists([]byte(chainworkBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		err = b.Put([]byte("l"), newBlock.Hash)
+		work := blockWork(block.Bits)
+		if len(block.PrevBlockHash) != 0 {
+			work.Add(work, getChainWork(tx, block.PrevBlockHash))
+		}
+
+		err = b.Put(block.Hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+		err = wb.Put(block.Hash, work.Bytes())
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		bc.tip = newBlock.Hash
+		if len(bc.tip) != 0 && work.Cmp(getChainWork(tx, bc.tip)) <= 0 {
+			log.Printf("Stored side branch block %x\n", block.Hash)
+			return nil
+		}
+
+		if bytes.Equal(block.PrevBlockHash, bc.tip) {
+			err = connectBlock(tx, block)
+			if err == nil {
+				err = putHeight(tx, block)
+			}
+			if err == nil && len(block.PrevBlockHash) == 0 {
+				err = putGenesis(tx, block)
+			}
+		} else {
+			err = bc.reorganize(tx, block)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte("l"), block.Hash)
+		if err != nil {
+			return err
+		}
+
+		bc.tip = block.Hash
 
 		return nil
 	})
+}
 
-	if err != nil {
+// reorganize 将主链切换到以block为tip的分支
+// 从当前tip回滚到分叉点，再从分叉点依次连接新分支上的区块
+func (bc *Blockchain) reorganize(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	mainChain := make(map[string]bool)
+	for hash := bc.tip; len(hash) != 0; {
+		mainChain[hex.EncodeToString(hash)] = true
+		hash = DeserializeBlock(b.Get(hash)).PrevBlockHash
+	}
+
+	// 新分支上不在主链中的区块，从新到旧排列
+	var attach []*Block
+	fork := block.PrevBlockHash
+	attach = append(attach, block)
+	for len(fork) != 0 && !mainChain[hex.EncodeToString(fork)] {
+		parent := DeserializeBlock(b.Get(fork))
+		attach = append(attach, parent)
+		fork = parent.PrevBlockHash
+	}
+
+	detached := 0
+	for hash := bc.tip; !bytes.Equal(hash, fork); {
+		tip := DeserializeBlock(b.Get(hash))
+		err := disconnectBlock(tx, tip)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket([]byte(heightsBucket)).Delete(IntToHex(int64(tip.Height)))
+		if err != nil {
+			return err
+		}
+		detached++
+		hash = tip.PrevBlockHash
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		err := connectBlock(tx, attach[i])
+		if err != nil {
+			return err
+		}
+		err = putHeight(tx, attach[i])
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+	}
+
+	log.Printf("Reorganize: fork at %x, disconnected %d blocks, connected %d blocks, new tip %x\n",
+		fork, detached, len(attach), block.Hash)
+
+	return nil
+}
+
+// putHeight 将主链上区块的高度写入高度索引
+func putHeight(tx *bolt.Tx, block *Block) error {
+	hb, err := tx.CreateBucketIfNotExists([]byte(heightsBucket))
+	if err != nil {
+		return err
+	}
+
+	return hb.Put(IntToHex(int64(block.Height)), block.Hash)
+}
+
+// putGenesis 在meta bucket中记录链的创世区块，打开db时由checkGenesis检查
+func putGenesis(tx *bolt.Tx, block *Block) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	return meta.Put([]byte(genesisKey), block.Hash)
+}
+
+// getChainWork 返回从创世区块到blockHash的累计工作量
+// 旧版本的db中没有保存累计工作量，此时沿父区块回溯计算
+func getChainWork(tx *bolt.Tx, blockHash []byte) *big.Int {
+	b := tx.Bucket([]byte(blocksBucket))
+	wb := tx.Bucket([]byte(chainworkBucket))
+
+	work := big.NewInt(0)
+	for hash := blockHash; len(hash) != 0; {
+		if wb != nil {
+			if stored := wb.Get(hash); stored != nil {
+				return work.Add(work, new(big.Int).SetBytes(stored))
+			}
+		}
+
+		block := DeserializeBlock(b.Get(hash))
+		work.Add(work, blockWork(block.Bits))
+		hash = block.PrevBlockHash
+	}
+
+	return work
+}
+
+// HasBlock 判断区块是否已经保存在db中
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	_, err := bc.GetBlock(blockHash)
+	return err == nil
+}
+
+// GetBlock 通过hash查找区块
+func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
+	var block Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		blockData := b.Get(blockHash)
+		if blockData == nil || len(blockHash) == 0 {
+			return errors.New("Block is not found")
 		}
+		block = *DeserializeBlock(blockData)
 
+		return nil
+	})
+
+	return block, err
+}
+
+// GetBlockByHeight 通过高度索引查找主链上的区块
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	var blockHash []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		hb := tx.Bucket([]byte(heightsBucket))
+		if hb == nil {
+			return nil
+		}
+		if hash := hb.Get(IntToHex(int64(height))); hash != nil {
+			blockHash = append([]byte{}, hash...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Block{}, err
+	}
+	if blockHash == nil {
+		return Block{}, errors.New("Block is not found")
+	}
+
+	return bc.GetBlock(blockHash)
+}
+
+// GetBlockHashes 返回链中所有区块的hash，从tip到创世区块
+func (bc *Blockchain) GetBlockHashes() [][]byte {
+	var blocks [][]byte
+	bci := bc.Iterator()
+
+	for bci.HasNext() {
+		block := bci.Next()
+		blocks = append(blocks, block.Hash)
 	}
+
+	return blocks
+}
+
+// GetBestHeight 返回tip的高度，创世区块的高度为0，空链为-1
+func (bc *Blockchain) GetBestHeight() int {
+	if len(bc.tip) == 0 {
+		return -1
+	}
+
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return tip.Height
 }
 
 // SignTransaction 签署交易的输入
 // 用私钥对交易进行签名
 func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	tx.Sign(privKey, prevTXs)
 }
 
-// VerifyTransaction 验证交易输入签名
+// VerifyTransaction 验证交易输入签名与输入输出的金额
+// 输入引用的输出不在chainstate中时验证失败
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
-	prevTXs := make(map[string]Transaction)
+	if tx.IsCoinbase() {
+		return true
+	}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		return false
 	}
 
 	return tx.Verify(prevTXs)
 }
 
+// TransactionFee 返回交易的手续费
+func (bc *Blockchain) TransactionFee(tx *Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	prevTXs, err := bc.prevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return tx.Fee(prevTXs)
+}
+
+// prevTransactions 从chainstate中查找交易输入所引用的输出
+// 返回的交易只包含ID与被引用的输出，输入引用的输出已经被使用或不存在时返回错误
+func (bc *Blockchain) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	var prevTXs map[string]Transaction
+
+	err := bc.db.View(func(dbtx *bolt.Tx) error {
+		var err error
+		prevTXs, err = utxoPrevTransactions(dbtx.Bucket([]byte(utxoBucket)), tx)
+		return err
+	})
+
+	return prevTXs, err
+}
+
 // FindTransaction 通过 ID 查找交易
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 	bci := bc.Iterator()
@@ -119,96 +418,46 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transaction is not found")
 }
 
-// FindUTXO 查找并返回所有未使用的交易输出
-func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []TXOutput {
-	var UTXOs []TXOutput
-	unspentTransactions := bc.FindUnspentTransactions(pubKeyHash)
-
-	for _, tx := range unspentTransactions {
-		for _, out := range tx.Vout {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTXOs = append(UTXOs, out)
-			}
-		}
-	}
-
-	return UTXOs
-}
-
-// FindSpendableOutputs 查找并返回未使用的输出以在输入中引用
-func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
-	accumulated := 0
-Work:
-	for _, tx := range unspentTXs {
-		txID := hex.EncodeToString(tx.ID)
+// FindUsedPubKeyHashes 返回主链上所有P2PKH输出锁定的公钥hash，key为hex编码
+func (bc *Blockchain) FindUsedPubKeyHashes() map[string]bool {
+	used := make(map[string]bool)
+	bci := bc.Iterator()
 
-		// 遍历包含未使用输出的交易
-		for outIdx, out := range tx.Vout {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
-				// 在未使用输出添加符合条件的输出  map[存储未使用输出的交易的ID]这个交易中
-				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+	for bci.HasNext() {
+		block := bci.Next()
 
-				if accumulated >= amount {
-					break Work
+		for _, tx := range block.Transactions {
+			for _, out := range tx.Vout {
+				if pubKeyHash, ok := ExtractPubKeyHash(out.ScriptPubKey); ok {
+					used[hex.EncodeToString(pubKeyHash)] = true
 				}
 			}
 		}
 	}
-	return accumulated, unspentOutputs
+
+	return used
 }
 
-// FindUnspentTransactions 返回包含未使用输出的交易列表
-func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
-	var unspentTXs []Transaction
-	spentTXOs := make(map[string][]int)
+// FindMerkleProof 查找ID对应的交易所在的区块，并生成其默克尔包含证明
+// 使用 VerifyMerkleProof(block.HashTransactions(), ID, proof) 验证
+func (bc *Blockchain) FindMerkleProof(ID []byte) (*Block, *MerkleProof, error) {
 	bci := bc.Iterator()
 
 	for bci.HasNext() {
 		block := bci.Next()
-		// 遍历当前块中的交易
-		// 如果块中有多个交易，要保证交易发生顺序与遍历顺序相反，即遍历的第一个交易一定是最后一个交易
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-		Outputs:
-			// 遍历当前交易的输出
-			for outIdx, out := range tx.Vout {
-				if spentTXOs[txID] != nil {
-					// 遍历发出的输入
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
-				}
-				// 判断当前输出是否由其接受，相当于这个账户收到的钱
-				if out.IsLockedWithKey(pubKeyHash) {
-					// 将这个交易加入到包含由未使用输出的集合中去
-					unspentTXs = append(unspentTXs, *tx)
-				}
-			}
-			// 如果当前交易不是Coinbase
-			if !tx.IsCoinbase() {
-				// 遍历当前交易的输入
-				for _, in := range tx.Vin {
-					// 检查当前输入是否由尤其发起，相当于这个支付出去的钱
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.Txid)
-						// 将引用输出的索引与交易ID存入，相当与这个账户在这个交易支付出去的钱的集合
-						spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-					}
-				}
-			}
+
+		proof, err := block.MerkleProof(ID)
+		if err == nil {
+			return block, proof, nil
 		}
 	}
-	return unspentTXs
+
+	return nil, nil, errors.New("Transaction is not found")
 }
 
 // dbExists 判断db文件是否存在
 func dbExists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+	if _, err := os.Stat(dataFilePath(dbFile)); os.IsNotExist(err) {
 		return false
 	}
 	return true
@@ -222,66 +471,180 @@ func NewBlockchain(address string) *Blockchain {
 	}
 
 	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	var hasUTXO bool
+	db, err := bolt.Open(dataFilePath(dbFile), 0600, nil)
 	if err != nil {
 		log.Panic(err)
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
+		if err := prepareDB(tx); err != nil {
+			return err
+		}
+
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		hasUTXO = tx.Bucket([]byte(utxoBucket)) != nil && tx.Bucket([]byte(undoBucket)) != nil &&
+			tx.Bucket([]byte(heightsBucket)) != nil
 
 		return nil
 	})
 
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
 	}
 
 	bc := Blockchain{tip, db}
 
+	// 旧版本的db中没有chainstate、undo数据或高度索引，首次打开时重建
+	if !hasUTXO {
+		UTXOSet{&bc}.Reindex()
+	}
+	bc.advanceMockTimeToTip()
+
 	return &bc
 }
 
-// CreateBlockchain 创建一个DB
-func CreateBlockchain(address string) *Blockchain {
+// CreateBlockchain 创建一个DB并加入当前网络的创世区块
+func CreateBlockchain() *Blockchain {
 	if dbExists() {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	ensureDataDir()
+	db, err := bolt.Open(dataFilePath(dbFile), 0600, nil)
 	if err != nil {
 		log.Panic(err)
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-		genesis := NewGenesisBlock(cbtx)
-		b, err := tx.CreateBucket([]byte(blocksBucket))
-		if err != nil {
-			log.Panic(err)
-		}
-		err = b.Put(genesis.Hash, genesis.Serialize())
+		_, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		err = b.Put([]byte("l"), genesis.Hash)
+		return prepareDB(tx)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := &Blockchain{nil, db}
+
+	err = bc.AddBlock(NewGenesisBlock(activeNet))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return bc
+}
+
+// OpenBlockchain 打开链，db不存在时创建一个没有区块的空链
+// 用于节点从其他节点同步包括创世区块在内的所有区块
+func OpenBlockchain() *Blockchain {
+	var tip []byte
+	var hasUTXO bool
+	ensureDataDir()
+	db, err := bolt.Open(dataFilePath(dbFile), 0600, nil)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(blocksBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		if err := prepareDB(tx); err != nil {
+			return err
 		}
-		tip = genesis.Hash
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		hasUTXO = tx.Bucket([]byte(utxoBucket)) != nil && tx.Bucket([]byte(undoBucket)) != nil &&
+			tx.Bucket([]byte(heightsBucket)) != nil
+
 		return nil
 	})
 
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+
+	bc := Blockchain{tip, db}
+
+	if !hasUTXO {
+		UTXOSet{&bc}.Reindex()
+	}
+	bc.advanceMockTimeToTip()
+
+	return &bc
+}
+
+// prepareDB 在打开db的事务中检查链属于当前网络、转换旧格式的db并加载共识引擎
+// 检查在转换之前进行；任何一步失败时整个事务回滚，db保持原样，旧版本的程序仍然可以打开
+func prepareDB(tx *bolt.Tx) error {
+	if err := checkGenesis(tx); err != nil {
+		return err
+	}
+	if err := migrateDB(tx); err != nil {
+		return err
+	}
+
+	return loadConsensus(tx)
+}
+
+// checkGenesis 检查db中记录的创世区块是当前网络的创世区块
+// 创建或从其他节点同步的链由putGenesis记录创世区块；固定创世区块之前创建的链没有记录，继续使用其原有的创世区块
+func checkGenesis(tx *bolt.Tx) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil || meta.Get([]byte(formatKey)) == nil {
+		// 转换格式之前记录的创世区块由migrateDB删除，见migrateDB
+		return nil
+	}
+
+	genesis := meta.Get([]byte(genesisKey))
+	if genesis != nil && !isGenesisHash(genesis) {
+		return fmt.Errorf("Blockchain in %s starts with block %x, not the %s genesis block %s", dataFilePath(dbFile), genesis, activeNet.Name, activeNet.GenesisHash)
+	}
+
+	return nil
+}
+
+// genesisHash 返回主链的创世区块hash，空链返回当前网络的创世区块hash
+func (bc *Blockchain) genesisHash() []byte {
+	var hash []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		if hb := tx.Bucket([]byte(heightsBucket)); hb != nil {
+			if h := hb.Get(IntToHex(0)); h != nil {
+				hash = append([]byte{}, h...)
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		log.Panic(err)
 	}
+	if hash == nil {
+		hash, _ = hex.DecodeString(activeNet.GenesisHash)
+	}
+
+	return hash
+}
 
-	return &Blockchain{
-		tip: tip,
-		db:  db,
+// advanceMockTimeToTip 使用-mocktime时将clock推进到tip的时间戳
+// 之前生成的区块的时间戳可能晚于-mocktime，推进之后这些区块不会被当作未来的区块
+func (bc *Blockchain) advanceMockTimeToTip() {
+	if mockTime == 0 || len(bc.tip) == 0 {
+		return
+	}
+
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		log.Panic(err)
 	}
+	advanceMockTime(tip.Timestamp)
 }
 
 type BlockchainIterator struct {
@@ -1,25 +1,170 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 )
 
 // CLI responsible for processing command line arguments
 type CLI struct{}
 
 // createWallet 创建钱包
-func (cli *CLI) createWallet() {
+// hd为true且钱包还没有HD种子时，生成助记词与种子，此后的地址都由种子派生
+// 钱包已加密时需要口令
+func (cli *CLI) createWallet(hd bool, passphrase string) {
 	wallets, _ := NewWallets()
-	address := wallets.CreateWallet()
+	cli.unlockWallets(wallets, passphrase)
+
+	if hd && !wallets.IsHD() {
+		mnemonic, err := NewMnemonic()
+		if err != nil {
+			log.Panic(err)
+		}
+		seed, err := MnemonicToSeed(mnemonic)
+		if err != nil {
+			log.Panic(err)
+		}
+		err = wallets.SetSeed(seed)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		fmt.Printf("Your mnemonic: %s\n", mnemonic)
+		fmt.Println("Write it down and keep it safe, it restores all addresses of this wallet")
+	}
+
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Panic(err)
+	}
 	wallets.SaveToFile()
 
 	fmt.Printf("Your new address: %s\n", address)
 }
 
+// restoreWallet 由助记词恢复HD钱包
+// 链存在时派生地址直到连续hdGapLimit个地址未被使用，否则只恢复第一个地址
+func (cli *CLI) restoreWallet(mnemonic, passphrase string) {
+	seed, err := MnemonicToSeed(mnemonic)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, _ := NewWallets()
+	cli.unlockWallets(wallets, passphrase)
+
+	err = wallets.SetSeed(seed)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	used := make(map[string]bool)
+	if dbExists() {
+		bc := NewBlockchain("")
+		used = bc.FindUsedPubKeyHashes()
+		bc.db.Close()
+	}
+	err = wallets.ScanHDAddresses(func(address string) bool {
+		pubKeyHash := Base58Decode([]byte(address))
+		pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+		return used[hex.EncodeToString(pubKeyHash)]
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile()
+
+	for _, address := range wallets.GetAddresses() {
+		fmt.Println(address)
+	}
+	fmt.Println("Wallet restored")
+}
+
+// encryptWallet 使用口令加密钱包
+func (cli *CLI) encryptWallet(passphrase string) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.EncryptWallet(passphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile()
+
+	fmt.Println("Wallet encrypted")
+}
+
+// walletPassphrase 校验钱包口令
+// 命令行每次运行都重新加载钱包，需要签名的命令通过 -passphrase 或输入口令解锁
+func (cli *CLI) walletPassphrase(passphrase string) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.Unlock(passphrase, 0)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.Lock()
+
+	fmt.Println("Passphrase is correct")
+}
+
+// changePassphrase 修改钱包口令
+func (cli *CLI) changePassphrase(oldPassphrase, newPassphrase string) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = wallets.ChangePassphrase(oldPassphrase, newPassphrase)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile()
+
+	fmt.Println("Passphrase changed")
+}
+
+// unlockWallets 钱包已加密时使用口令解锁，口令为空时从标准输入读取
+func (cli *CLI) unlockWallets(wallets *Wallets, passphrase string) {
+	if !wallets.IsEncrypted() {
+		return
+	}
+
+	if passphrase == "" {
+		passphrase = readPassphrase("Enter wallet passphrase: ")
+	}
+
+	err := wallets.Unlock(passphrase, 0)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// readPassphrase 从标准输入读取一行口令
+func readPassphrase(prompt string) string {
+	fmt.Print(prompt)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Panic(err)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
+
 // listAddresses 列出所有钱包的地址
 func (cli *CLI) listAddresses() {
 	wallets, err := NewWallets()
@@ -34,12 +179,22 @@ func (cli *CLI) listAddresses() {
 }
 
 // createBlockchain 创建链
+// 创世区块的奖励无法使用，address不为空时继续挖出第一个区块，奖励发送给address
 func (cli *CLI) createBlockchain(address string) {
-	if !ValidateAddress(address) {
+	if address != "" && !ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := CreateBlockchain(address)
-	bc.db.Close()
+	bc := CreateBlockchain()
+	defer bc.db.Close()
+
+	if address != "" {
+		block, err := bc.MineBlock(context.Background(), address, nil)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Mined block %x\n", block.Hash)
+	}
 	fmt.Println("Done!")
 }
 
@@ -49,12 +204,11 @@ func (cli *CLI) getBalance(address string) {
 		log.Panic("ERROR: Address is not valid")
 	}
 	bc := NewBlockchain(address)
+	UTXOSet := UTXOSet{bc}
 	defer bc.db.Close()
 
 	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := bc.FindUTXO(pubKeyHash)
+	UTXOs := UTXOSet.FindUTXO(addressToScript(address))
 
 	for _, out := range UTXOs {
 		balance += out.Value
@@ -63,6 +217,24 @@ func (cli *CLI) getBalance(address string) {
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
 }
 
+// getSupply 打印当前的发行量
+// 流通量为UTXO集中所有输出的金额之和，与计划发行量的差为未被领取的挖矿奖励
+func (cli *CLI) getSupply() {
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	height := bc.GetBestHeight()
+
+	fmt.Printf("Height: %d\n", height)
+	fmt.Printf("Block subsidy: %d\n", BlockSubsidy(height+1))
+	spendable, unspendable := UTXOSet.TotalValue()
+	fmt.Printf("Issued: %d\n", spendable)
+	fmt.Printf("Unspendable: %d\n", unspendable)
+	fmt.Printf("Scheduled: %d\n", ScheduledSupply(height))
+	fmt.Printf("Max supply: %d\n", activeNet.MaxSupply)
+}
+
 // printChain 打印链
 func (cli *CLI) printChain() {
 	// TODO: Fix this
@@ -74,10 +246,11 @@ func (cli *CLI) printChain() {
 	for {
 		block := bci.Next()
 
+		fmt.Printf("============ Block %d ============\n", block.Height)
 		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
 		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		fmt.Printf("Seal: %s\n", strconv.FormatBool(bc.verifySeal(block) == nil))
 		fmt.Println()
 
 		if len(block.PrevBlockHash) == 0 {
@@ -86,8 +259,40 @@ func (cli *CLI) printChain() {
 	}
 }
 
+// verifyChain 检查db中最近depth个区块，发现问题时打印第一个有问题的区块并以状态1退出
+func (cli *CLI) verifyChain(level, depth int) {
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	checked, err := bc.VerifyChain(level, depth)
+	if err != nil {
+		fmt.Printf("Verified %d blocks at level %d\n", checked, level)
+		fmt.Printf("ERROR: %s\n", err)
+		bc.db.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Verified %d blocks at level %d, no problems found\n", checked, level)
+}
+
+// reindexUTXO 重建chainstate
+func (cli *CLI) reindexUTXO() {
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	count := UTXOSet.CountTransactions()
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
+
 // send 发送交易
-func (cli *CLI) send(from, to string, amount int) {
+// node为空时将交易加入本地交易池，等待mine打包
+// 否则将交易发送给node，由网络中的矿工打包
+// 钱包已加密时使用passphrase解锁，为空时从标准输入读取
+// lockTime、sequence不为0时，交易在满足锁定时间之后才能被打包
+func (cli *CLI) send(from, to string, amount, fee int, lockTime, sequence uint32, node, passphrase string) {
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
@@ -98,24 +303,382 @@ func (cli *CLI) send(from, to string, amount int) {
 	bc := NewBlockchain(from)
 	defer bc.db.Close()
 
-	tx := NewUTXOTransaction(from, to, amount, bc)
-	bc.MineBlock([]*Transaction{tx})
-	fmt.Println("Success!")
+	UTXOSet := UTXOSet{bc}
+	mempool := NewMempool(&UTXOSet, bc.db)
+
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.unlockWallets(wallets, passphrase)
+	wallet := wallets.GetWallet(from)
+
+	tx := NewUTXOTransaction(&wallet, to, amount, fee, lockTime, sequence, &UTXOSet, mempool)
+	if node == "" {
+		err = mempool.Add(*tx, &UTXOSet)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Transaction %x added to mempool\n", tx.ID)
+	} else {
+		SendTransaction(node, tx)
+		fmt.Printf("Transaction %x sent to %s\n", tx.ID, node)
+	}
+}
+
+// getPubKey 打印钱包中地址的公钥，用于创建多重签名地址
+func (cli *CLI) getPubKey(address string) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panic("ERROR: Address is not in the wallet")
+	}
+
+	fmt.Printf("%x\n", wallet.PublicKey)
+}
+
+// createMultisig 由M与N个公钥创建多重签名地址
+// keys中的每一项可以是hex编码的公钥，也可以是本地钱包中的地址
+func (cli *CLI) createMultisig(m int, keys []string) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var pubKeys [][]byte
+	for _, key := range keys {
+		if wallet, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			log.Panicf("ERROR: %s is neither a wallet address nor a public key", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+
+	redeemScript, err := NewMultisigScript(m, pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Multisig address: %s\n", MultisigAddress(redeemScript))
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+}
+
+// createMultisigTx 创建从多重签名地址支付的未签名交易，保存到file
+func (cli *CLI) createMultisigTx(redeemScriptHex, to string, amount, fee int, file string) {
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+	redeemScript, err := hex.DecodeString(redeemScriptHex)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	mempool := NewMempool(&UTXOSet, bc.db)
+
+	ptx, err := NewMultisigTransaction(redeemScript, to, amount, fee, &UTXOSet, mempool)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = ptx.SaveToFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Unsigned transaction saved to %s\n", file)
+}
+
+// signMultisigTx 使用address的私钥为file中的交易签名
+func (cli *CLI) signMultisigTx(file, address, passphrase string) {
+	ptx, err := LoadPartialTransaction(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, ok := wallets.Wallets[address]; !ok {
+		log.Panic("ERROR: Address is not in the wallet")
+	}
+	cli.unlockWallets(wallets, passphrase)
+	wallet := wallets.GetWallet(address)
+
+	signed, err := ptx.Sign(&wallet)
+	if err != nil {
+		log.Panic(err)
+	}
+	if signed == 0 {
+		log.Panic("ERROR: The key of the address is not part of any input")
+	}
+	err = ptx.SaveToFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Signed %d inputs\n", signed)
+}
+
+// createRawTx 创建从from支付给to的未签名交易，与所引用的输出一起保存到file
+func (cli *CLI) createRawTx(from, to string, amount, fee int, file string) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	mempool := NewMempool(&UTXOSet, bc.db)
+
+	ptx, err := NewRawTransaction(from, to, amount, fee, &UTXOSet, mempool)
+	if err != nil {
+		log.Panic(err)
+	}
+	err = ptx.SaveToFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Unsigned transaction saved to %s\n", file)
+}
+
+// signRawTx 使用钱包中所有可以签名的私钥为file中的交易签名
+// 只读取文件与钱包，不访问区块链，可以在离线的机器上运行
+func (cli *CLI) signRawTx(file, passphrase string) {
+	ptx, err := LoadPartialTransaction(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for i, out := range ptx.Tx.Vout {
+		fmt.Printf("Output %d: %d to %s\n", i, out.Value, out.Address())
+	}
+	fmt.Printf("Fee: %d\n", ptx.Fee())
+
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.unlockWallets(wallets, passphrase)
+
+	signed := 0
+	for _, address := range wallets.GetAddresses() {
+		wallet := wallets.GetWallet(address)
+		n, err := ptx.Sign(&wallet)
+		if err != nil {
+			log.Panic(err)
+		}
+		signed += n
+	}
+	if signed == 0 {
+		log.Panic("ERROR: No input can be signed with the keys in the wallet")
+	}
+	err = ptx.SaveToFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Added %d signatures\n", signed)
+}
+
+// sendRawTx 签名足够后生成最终交易，验证通过后提交
+// node为空时将交易加入本地交易池，否则发送给node
+func (cli *CLI) sendRawTx(file, node string) {
+	ptx, err := LoadPartialTransaction(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tx, err := ptx.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	if !bc.VerifyTransaction(tx) {
+		log.Panic("ERROR: Invalid transaction")
+	}
+
+	if node == "" {
+		UTXOSet := UTXOSet{bc}
+		mempool := NewMempool(&UTXOSet, bc.db)
+
+		err = mempool.Add(*tx, &UTXOSet)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Transaction %x added to mempool\n", tx.ID)
+	} else {
+		SendTransaction(node, tx)
+		fmt.Printf("Transaction %x sent to %s\n", tx.ID, node)
+	}
+}
+
+// mine 将交易池中的交易打包成区块，挖矿奖励与手续费支付给address
+// maxTx<=0时打包全部交易
+func (cli *CLI) mine(address string, maxTx int) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := NewBlockchain(address)
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	mempool := NewMempool(&UTXOSet, bc.db)
+
+	// Ctrl-C 中止挖矿
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	txs := mempool.Transactions(maxTx, &UTXOSet)
+	block, err := bc.MineBlock(ctx, address, txs)
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("Mining aborted")
+			return
+		}
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	mempool.RemoveBlockTransactions(block)
+
+	fmt.Printf("Mined block %x with %d transactions, %d left in mempool\n", block.Hash, len(block.Transactions)-1, mempool.Count())
+}
+
+// generate 立即挖出n个区块，每个区块打包交易池中的全部交易，挖矿奖励发送给address
+// 只能在regtest中使用
+func (cli *CLI) generate(n int, address string) {
+	if activeNet != &regTestParams {
+		fmt.Println("generate is only available on regtest")
+		os.Exit(1)
+	}
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := NewBlockchain(address)
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	mempool := NewMempool(&UTXOSet, bc.db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for i := 0; i < n; i++ {
+		// 使用-mocktime时每个区块的时间戳比上一个区块晚TargetSpacing秒
+		tip, err := bc.GetBlock(bc.tip)
+		if err != nil {
+			log.Panic(err)
+		}
+		advanceMockTime(tip.Timestamp + activeNet.TargetSpacing)
+
+		block, err := bc.MineBlock(ctx, address, mempool.Transactions(0, &UTXOSet))
+		if err != nil {
+			if ctx.Err() != nil {
+				fmt.Println("Mining aborted")
+				return
+			}
+			fmt.Printf("ERROR: %s\n", err)
+			os.Exit(1)
+		}
+		mempool.RemoveBlockTransactions(block)
+
+		fmt.Printf("%x\n", block.Hash)
+	}
+}
+
+// startRPC 启动JSON-RPC服务
+func (cli *CLI) startRPC(listen string) {
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	server := NewRPCServer(listen, bc)
+	server.Start()
+}
+
+// serveExplorer 启动区块浏览器
+func (cli *CLI) serveExplorer(listen string) {
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	explorer := NewExplorer(listen, bc)
+	explorer.Start()
+}
+
+// startNode 启动节点
+func (cli *CLI) startNode(port, minerAddress string, seeds []string) {
+	if minerAddress != "" && !ValidateAddress(minerAddress) {
+		log.Panic("ERROR: Miner address is not valid")
+	}
+
+	bc := OpenBlockchain()
+	defer bc.db.Close()
+
+	server := NewServer(port, minerAddress, seeds, bc)
+	server.Start()
 }
 
 // printUsage 打印Usage
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("Usage: block [-datadir DIR] [-network NETWORK] [-mocktime TIMESTAMP] [-threads N] [-consensus poa -signers ADDRESS,...] COMMAND [OPTIONS]")
+	fmt.Println("  -datadir DIR - Directory of the blockchain database and the wallet file, defaults to $" + dataDirEnv + " or the current directory")
+	fmt.Println("  -network NETWORK - mainnet (default), testnet or regtest. Other networks keep their files in a subdirectory of the data directory")
+	fmt.Println("  -mocktime TIMESTAMP - Use TIMESTAMP as the current time so that blocks are reproducible, regtest only")
+	fmt.Println("  -threads N - Number of goroutines used for mining, defaults to the number of CPUs")
+	fmt.Println("  -consensus poa -signers ADDRESS,... - Use Proof-of-Authority instead of proof of work: blocks are signed in turn by the signers with keys from the wallet, unlocked with $" + signerPassphraseEnv + " if it is encrypted")
+	fmt.Println("Commands:")
+	fmt.Println("  createblockchain [-address ADDRESS] - Create a blockchain with the genesis block of the network. With -address, also mine the first block and send its reward to ADDRESS")
+	fmt.Println("  changepassphrase -old OLD -new NEW - Change the wallet passphrase")
+	fmt.Println("  createmultisig -m M -keys KEY,... - Create an M-of-N multisig address from public keys or wallet addresses")
+	fmt.Println("  createmultisigtx -redeemscript HEX -to TO -amount AMOUNT [-fee FEE] -file FILE - Create an unsigned transaction spending from a multisig address and save it to FILE")
+	fmt.Println("  createrawtx -from FROM -to TO -amount AMOUNT [-fee FEE] -file FILE - Create an unsigned transaction and save it with the outputs it spends to FILE")
+	fmt.Println("  createwallet [-hd] [-passphrase PASSPHRASE] - Generates a new key-pair and saves it into the wallet file. With -hd, creates a mnemonic seed and derives addresses from it")
+	fmt.Println("  encryptwallet -passphrase PASSPHRASE - Encrypts the private keys in the wallet file with PASSPHRASE")
+	fmt.Println("  finalizemultisigtx -file FILE [-node HOST:PORT] - Build the multisig transaction in FILE once it has enough signatures and add it to the mempool or send it to -node")
+	fmt.Println("  generate -n N -address ADDRESS - Mine N blocks immediately with the mempool transactions and send the rewards to ADDRESS, regtest only")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  getpubkey -address ADDRESS - Print the public key of a wallet address")
+	fmt.Println("  getsupply - Print the number of coins issued so far and the current block subsidy")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
+	fmt.Println("  mine -address ADDRESS [-max-tx N] - Mine a block with up to N transactions from the mempool and send the reward to ADDRESS")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  restorewallet -mnemonic MNEMONIC [-passphrase PASSPHRASE] - Restores the HD wallet and its used addresses from MNEMONIC")
+	fmt.Println("  serveexplorer [-listen HOST:PORT] - Start a read-only block explorer with a REST API and a web UI")
+	fmt.Println("  sendrawtx -file FILE [-node HOST:PORT] - Verify the signed transaction in FILE and add it to the mempool or send it to -node")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-fee FEE] [-locktime N] [-sequence N] [-node HOST:PORT] [-passphrase PASSPHRASE] - Send AMOUNT of coins from FROM address to TO, paying FEE to the miner. Add to the local mempool unless -node is set")
+	fmt.Println("  signrawtx -file FILE [-passphrase PASSPHRASE] - Sign the transaction in FILE with the keys in the wallet, without accessing the blockchain")
+	fmt.Println("  signmultisigtx -file FILE -address ADDRESS [-passphrase PASSPHRASE] - Add the signature of ADDRESS to the multisig transaction in FILE")
+	fmt.Println("  verifychain [-level N] [-depth M] - Check the last M blocks (all if 0) of the database at level N (0-3) and report the first bad block")
+	fmt.Println("  walletpassphrase -passphrase PASSPHRASE - Check that PASSPHRASE unlocks the wallet")
+	fmt.Println("  startrpc [-listen HOST:PORT] - Start a JSON-RPC 2.0 server over HTTP")
+	fmt.Println("  startnode [-port PORT] [-miner ADDRESS] [-seeds HOST:PORT,...] - Start a node listening on PORT, mining to ADDRESS if set")
 }
 
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CLI) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
@@ -123,49 +686,270 @@ func (cli *CLI) validateArgs() {
 
 // Run parses command line arguments and processes commands
 func (cli *CLI) Run() {
-	cli.validateArgs()
+	globalCmd := flag.NewFlagSet("block", flag.ExitOnError)
+	globalCmd.Usage = cli.printUsage
+	globalDataDir := globalCmd.String("datadir", os.Getenv(dataDirEnv), "Directory of the blockchain database and the wallet file, defaults to $"+dataDirEnv+" or the current directory")
+	globalNetwork := globalCmd.String("network", mainNetParams.Name, "Network to use: mainnet, testnet or regtest")
+	globalMockTime := globalCmd.Int64("mocktime", 0, "Use this Unix timestamp as the current time, regtest only")
+	globalThreads := globalCmd.Int("threads", miningThreads, "Number of goroutines used for mining")
+	globalConsensus := globalCmd.String("consensus", "pow", "Consensus engine: pow or poa")
+	globalSigners := globalCmd.String("signers", "", "Comma separated addresses that sign blocks in turn, poa only")
+	err := globalCmd.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+	if *globalDataDir != "" {
+		dataDir = *globalDataDir
+	}
+	err = SelectNetwork(*globalNetwork)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *globalThreads < 1 {
+		globalCmd.Usage()
+		os.Exit(1)
+	}
+	miningThreads = *globalThreads
+	globalCmd.Visit(func(f *flag.Flag) {
+		if f.Name == "consensus" || f.Name == "signers" {
+			consensusFromFlags = true
+		}
+	})
+	switch *globalConsensus {
+	case "pow":
+	case "poa":
+		var signers []string
+		if *globalSigners != "" {
+			signers = strings.Split(*globalSigners, ",")
+		}
+		activeEngine, err = NewPoAEngine(signers)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Unknown consensus %q\n", *globalConsensus)
+		os.Exit(1)
+	}
+	if *globalMockTime != 0 {
+		if activeNet != &regTestParams {
+			fmt.Println("-mocktime is only available on regtest")
+			os.Exit(1)
+		}
+		SetMockTime(*globalMockTime)
+	}
+
+	args := globalCmd.Args()
+	cli.validateArgs(args)
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("changepassphrase", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	startRPCCmd := flag.NewFlagSet("startrpc", flag.ExitOnError)
+	serveExplorerCmd := flag.NewFlagSet("serveexplorer", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultisigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	signMultisigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	finalizeMultisigTxCmd := flag.NewFlagSet("finalizemultisigtx", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "Mine the first block after the genesis block and send its reward to this address")
+	createWalletHD := createWalletCmd.Bool("hd", false, "Derive addresses from a mnemonic seed")
+	createWalletPassphrase := createWalletCmd.String("passphrase", "", "Passphrase of the encrypted wallet")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Mnemonic of the HD wallet")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase of the encrypted wallet")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "Passphrase to encrypt the wallet with")
+	walletPassphrasePassphrase := walletPassphraseCmd.String("passphrase", "", "Passphrase of the encrypted wallet")
+	changePassphraseOld := changePassphraseCmd.String("old", "", "Current passphrase")
+	changePassphraseNew := changePassphraseCmd.String("new", "", "New passphrase")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Transaction fee paid to the miner")
+	sendPassphrase := sendCmd.String("passphrase", "", "Passphrase of the encrypted wallet, read from stdin if empty")
+	sendLockTime := sendCmd.Uint("locktime", 0, "Block height (or Unix time if >= 500000000) before which the transaction cannot be mined")
+	sendSequence := sendCmd.Uint("sequence", 0, "Sequence of every input, encodes a relative locktime in blocks or 512 second units")
+	sendNode := sendCmd.String("node", "", "Send the transaction to this node instead of mining it locally")
+	mineAddress := mineCmd.String("address", "", "The address to send block reward to")
+	mineMaxTx := mineCmd.Int("max-tx", 0, "Maximum number of mempool transactions to include, 0 for all")
+	generateN := generateCmd.Int("n", 1, "Number of blocks to mine")
+	generateAddress := generateCmd.String("address", "", "The address to send block rewards to")
+	startRPCListen := startRPCCmd.String("listen", "127.0.0.1:"+activeNet.RPCPort, "Address to listen on, wallet methods require the token in "+rpcCookieFile+" unless it is a loopback address")
+	serveExplorerListen := serveExplorerCmd.String("listen", ":"+activeNet.ExplorerPort, "Address to listen on")
+	getPubKeyAddress := getPubKeyCmd.String("address", "", "Wallet address")
+	createMultisigM := createMultisigCmd.Int("m", 0, "Number of required signatures")
+	createMultisigKeys := createMultisigCmd.String("keys", "", "Comma separated public keys or wallet addresses")
+	createMultisigTxRedeemScript := createMultisigTxCmd.String("redeemscript", "", "Redeem script of the multisig address")
+	createMultisigTxTo := createMultisigTxCmd.String("to", "", "Destination wallet address")
+	createMultisigTxAmount := createMultisigTxCmd.Int("amount", 0, "Amount to send")
+	createMultisigTxFee := createMultisigTxCmd.Int("fee", 0, "Transaction fee paid to the miner")
+	createMultisigTxFile := createMultisigTxCmd.String("file", "", "File to save the unsigned transaction to")
+	signMultisigTxFile := signMultisigTxCmd.String("file", "", "File of the multisig transaction")
+	signMultisigTxAddress := signMultisigTxCmd.String("address", "", "Wallet address to sign with")
+	signMultisigTxPassphrase := signMultisigTxCmd.String("passphrase", "", "Passphrase of the encrypted wallet, read from stdin if empty")
+	finalizeMultisigTxFile := finalizeMultisigTxCmd.String("file", "", "File of the multisig transaction")
+	finalizeMultisigTxNode := finalizeMultisigTxCmd.String("node", "", "Send the transaction to this node instead of mining it locally")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source wallet address")
+	createRawTxTo := createRawTxCmd.String("to", "", "Destination wallet address")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send")
+	createRawTxFee := createRawTxCmd.Int("fee", 0, "Transaction fee paid to the miner")
+	createRawTxFile := createRawTxCmd.String("file", "", "File to save the unsigned transaction to")
+	signRawTxFile := signRawTxCmd.String("file", "", "File of the transaction")
+	signRawTxPassphrase := signRawTxCmd.String("passphrase", "", "Passphrase of the encrypted wallet, read from stdin if empty")
+	sendRawTxFile := sendRawTxCmd.String("file", "", "File of the signed transaction")
+	sendRawTxNode := sendRawTxCmd.String("node", "", "Send the transaction to this node instead of mining it locally")
+	verifyChainLevel := verifyChainCmd.Int("level", 3, "0: hashes and linkage, 1: proof of work and block structure, 2: signatures, 3: replay transactions and compare the UTXO set")
+	verifyChainDepth := verifyChainCmd.Int("depth", 0, "Number of blocks from the tip to check, 0 for all")
+	startNodePort := startNodeCmd.String("port", activeNet.DefaultPort, "Port to listen on")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeSeeds := startNodeCmd.String("seeds", activeNet.DefaultSeed(), "Comma separated addresses of nodes to connect to")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletpassphrase":
+		err := walletPassphraseCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "changepassphrase":
+		err := changePassphraseCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "startrpc":
+		err := startRPCCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "serveexplorer":
+		err := serveExplorerCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getsupply":
+		err := getSupplyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigtx":
+		err := createMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisigtx":
+		err := signMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "finalizemultisigtx":
+		err := finalizeMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -183,15 +967,43 @@ func (cli *CLI) Run() {
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			os.Exit(1)
-		}
 		cli.createBlockchain(*createBlockchainAddress)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		cli.createWallet(*createWalletHD, *createWalletPassphrase)
+	}
+
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletPassphrase)
+	}
+
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.encryptWallet(*encryptWalletPassphrase)
+	}
+
+	if walletPassphraseCmd.Parsed() {
+		if *walletPassphrasePassphrase == "" {
+			walletPassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletPassphrase(*walletPassphrasePassphrase)
+	}
+
+	if changePassphraseCmd.Parsed() {
+		if *changePassphraseOld == "" || *changePassphraseNew == "" {
+			changePassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.changePassphrase(*changePassphraseOld, *changePassphraseNew)
 	}
 
 	if listAddressesCmd.Parsed() {
@@ -202,12 +1014,124 @@ func (cli *CLI) Run() {
 		cli.printChain()
 	}
 
+	if reindexUTXOCmd.Parsed() {
+		cli.reindexUTXO()
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendLockTime > maxSequence || *sendSequence > maxSequence {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, uint32(*sendLockTime), uint32(*sendSequence), *sendNode, *sendPassphrase)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" || *generateN <= 0 {
+			generateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.generate(*generateN, *generateAddress)
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" || *mineMaxTx < 0 {
+			mineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.mine(*mineAddress, *mineMaxTx)
+	}
+
+	if startRPCCmd.Parsed() {
+		cli.startRPC(*startRPCListen)
+	}
+
+	if serveExplorerCmd.Parsed() {
+		cli.serveExplorer(*serveExplorerListen)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainLevel < 0 || *verifyChainLevel > 3 || *verifyChainDepth < 0 {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(*verifyChainLevel, *verifyChainDepth)
+	}
+
+	if getSupplyCmd.Parsed() {
+		cli.getSupply()
+	}
+
+	if getPubKeyCmd.Parsed() {
+		if *getPubKeyAddress == "" {
+			getPubKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getPubKey(*getPubKeyAddress)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigM <= 0 || *createMultisigKeys == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigM, strings.Split(*createMultisigKeys, ","))
+	}
+
+	if createMultisigTxCmd.Parsed() {
+		if *createMultisigTxRedeemScript == "" || *createMultisigTxTo == "" || *createMultisigTxAmount <= 0 || *createMultisigTxFee < 0 || *createMultisigTxFile == "" {
+			createMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisigTx(*createMultisigTxRedeemScript, *createMultisigTxTo, *createMultisigTxAmount, *createMultisigTxFee, *createMultisigTxFile)
+	}
+
+	if signMultisigTxCmd.Parsed() {
+		if *signMultisigTxFile == "" || *signMultisigTxAddress == "" {
+			signMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultisigTx(*signMultisigTxFile, *signMultisigTxAddress, *signMultisigTxPassphrase)
+	}
+
+	if finalizeMultisigTxCmd.Parsed() {
+		if *finalizeMultisigTxFile == "" {
+			finalizeMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTx(*finalizeMultisigTxFile, *finalizeMultisigTxNode)
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || *createRawTxTo == "" || *createRawTxAmount <= 0 || *createRawTxFee < 0 || *createRawTxFile == "" {
+			createRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createRawTx(*createRawTxFrom, *createRawTxTo, *createRawTxAmount, *createRawTxFee, *createRawTxFile)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxFile == "" {
+			signRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signRawTx(*signRawTxFile, *signRawTxPassphrase)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxFile == "" {
+			sendRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTx(*sendRawTxFile, *sendRawTxNode)
+	}
+
+	if startNodeCmd.Parsed() {
+		if *startNodePort == "" {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startNode(*startNodePort, *startNodeMiner, strings.Split(*startNodeSeeds, ","))
 	}
 }
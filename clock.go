@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// mockTime -mocktime设置的当前时间，为0时使用系统时间
+var mockTime int64
+
+// clock 返回当前的Unix时间戳
+// 区块的时间戳、未来区块的检查与交易池的锁定时间检查都使用clock，由-mocktime替换为固定的时间之后测试链可以重现
+var clock = func() int64 {
+	if mockTime != 0 {
+		return mockTime
+	}
+	return time.Now().Unix()
+}
+
+// SetMockTime 将clock固定为timestamp
+func SetMockTime(timestamp int64) {
+	mockTime = timestamp
+}
+
+// advanceMockTime 使用-mocktime时将clock推进到timestamp，clock已经不早于timestamp或使用系统时间时不改变
+// 连续生成区块时时间戳随区块前进，避免超过clock()+maxFutureBlockTime而被拒绝
+func advanceMockTime(timestamp int64) {
+	if mockTime != 0 && timestamp > mockTime {
+		mockTime = timestamp
+	}
+}
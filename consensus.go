@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// consensusKey meta bucket中保存链使用的共识引擎的key，值见engineConfig
+const consensusKey = "consensus"
+
+// ConsensusEngine 共识引擎，决定区块如何封装与验证
+type ConsensusEngine interface {
+	// Seal 封装区块，设置区块的Nonce、Hash与签名，ctx被取消时返回ctx.Err()
+	Seal(ctx context.Context, block *Block) error
+	// VerifySeal 验证区块的封装，区块的父区块需要已经在bc中
+	VerifySeal(bc *Blockchain, block *Block) error
+	// CalcDifficulty 计算父区块为prevHash的区块应当使用的Bits，prevHash为空时为创世区块
+	CalcDifficulty(bc *Blockchain, prevHash []byte) uint32
+}
+
+// activeEngine 当前使用的共识引擎，打开链时从db中加载，见Blockchain.loadConsensus
+var activeEngine ConsensusEngine = powEngine{}
+
+// consensusFromFlags 为true时activeEngine由-consensus与-signers指定，必须与链保存的共识引擎一致
+var consensusFromFlags bool
+
+// engineConfig 返回共识引擎保存在db中的配置: pow，或者poa:签名者1,签名者2,...
+func engineConfig(engine ConsensusEngine) string {
+	switch e := engine.(type) {
+	case *poaEngine:
+		return "poa:" + strings.Join(e.signers, ",")
+	default:
+		return "pow"
+	}
+}
+
+// parseEngineConfig 根据engineConfig返回的配置创建共识引擎
+func parseEngineConfig(config string) (ConsensusEngine, error) {
+	if config == "pow" {
+		return powEngine{}, nil
+	}
+	if signers := strings.TrimPrefix(config, "poa:"); signers != config {
+		return NewPoAEngine(strings.Split(signers, ","))
+	}
+
+	return nil, fmt.Errorf("Unknown consensus %q", config)
+}
+
+// loadConsensus 在打开db的事务中加载链使用的共识引擎
+// 链中还没有保存共识引擎时保存activeEngine；否则使用保存的引擎，-consensus指定了不同的引擎时返回错误
+func loadConsensus(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	config := engineConfig(activeEngine)
+	stored := meta.Get([]byte(consensusKey))
+	if stored == nil {
+		return meta.Put([]byte(consensusKey), []byte(config))
+	}
+
+	if consensusFromFlags {
+		if string(stored) != config {
+			return fmt.Errorf("Blockchain uses consensus %q, not %q", stored, config)
+		}
+		return nil
+	}
+
+	engine, err := parseEngineConfig(string(stored))
+	if err != nil {
+		return err
+	}
+	activeEngine = engine
+
+	return nil
+}
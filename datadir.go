@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const dataDirEnv = "BLOCK_DATADIR" // 未指定-datadir时使用的环境变量
+
+// dataDir 数据目录，保存db与钱包文件，由-datadir或BLOCK_DATADIR设置
+var dataDir = "."
+
+// networkDataDir 返回当前网络的数据目录
+// 主网直接使用数据目录，与旧版本保存在当前目录中的文件兼容，其他网络使用以网络名命名的子目录
+func networkDataDir() string {
+	if activeNet == &mainNetParams {
+		return dataDir
+	}
+	return filepath.Join(dataDir, activeNet.Name)
+}
+
+// dataFilePath 返回当前网络的数据目录中名为name的文件的路径
+func dataFilePath(name string) string {
+	return filepath.Join(networkDataDir(), name)
+}
+
+// ensureDataDir 创建当前网络的数据目录
+func ensureDataDir() {
+	err := os.MkdirAll(networkDataDir(), 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataFilePath(t *testing.T) {
+	defer func(net *ChainParams, dir string) {
+		activeNet = net
+		dataDir = dir
+	}(activeNet, dataDir)
+
+	tests := []struct {
+		net     *ChainParams
+		dataDir string
+		want    string
+	}{
+		// 主网不使用子目录，与旧版本的文件位置兼容
+		{&mainNetParams, ".", "blockchain.db"},
+		{&mainNetParams, "/var/lib/block", "/var/lib/block/blockchain.db"},
+		{&testNetParams, ".", "testnet/blockchain.db"},
+		{&regTestParams, "/var/lib/block", "/var/lib/block/regtest/blockchain.db"},
+	}
+
+	for _, tt := range tests {
+		activeNet = tt.net
+		dataDir = tt.dataDir
+		if got := dataFilePath("blockchain.db"); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s in %s: dataFilePath = %s, want %s", tt.net.Name, tt.dataDir, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureDataDir(t *testing.T) {
+	defer func(net *ChainParams, dir string) {
+		activeNet = net
+		dataDir = dir
+	}(activeNet, dataDir)
+
+	activeNet = &regTestParams
+	dataDir = filepath.Join(t.TempDir(), "data")
+	ensureDataDir()
+
+	info, err := os.Stat(filepath.Join(dataDir, "regtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("%s is not a directory", info.Name())
+	}
+}
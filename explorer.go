@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	explorerDefaultLimit = 20
+	explorerMaxLimit     = 500
+)
+
+//go:embed explorer.html
+var explorerPage []byte
+
+// Explorer 只读的区块浏览器
+// /blocks、/blocks/{hash}、/tx/{id}、/address/{addr} 返回JSON，/ 返回浏览器页面
+type Explorer struct {
+	listen string
+	bc     *Blockchain
+}
+
+// blockSummaryJSON 区块列表中的区块摘要
+type blockSummaryJSON struct {
+	Hash          string `json:"hash"`
+	PrevBlockHash string `json:"previousblockhash"`
+	Height        int    `json:"height"`
+	Timestamp     int64  `json:"time"`
+	Bits          string `json:"bits"`
+	TxCount       int    `json:"txcount"`
+}
+
+// addressJSON 地址的余额、未使用输出与相关交易
+type addressJSON struct {
+	Address      string         `json:"address"`
+	Balance      int            `json:"balance"`
+	UTXOs        []txOutputJSON `json:"utxos"`
+	Transactions []string       `json:"transactions"`
+}
+
+type explorerError struct {
+	Error string `json:"error"`
+}
+
+// NewExplorer 创建区块浏览器
+func NewExplorer(listen string, bc *Blockchain) *Explorer {
+	return &Explorer{listen, bc}
+}
+
+// Start 监听地址并处理请求
+func (e *Explorer) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", e.handleIndex)
+	mux.HandleFunc("/blocks", e.handleBlocks)
+	mux.HandleFunc("/blocks/", e.handleBlock)
+	mux.HandleFunc("/tx/", e.handleTransaction)
+	mux.HandleFunc("/address/", e.handleAddress)
+
+	log.Printf("Explorer listening on %s\n", e.listen)
+	log.Panic(http.ListenAndServe(e.listen, mux))
+}
+
+func (e *Explorer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(explorerPage)
+}
+
+// handleBlocks 从tip开始返回最多limit个区块的摘要
+// from 为起始区块的hash，用于翻页
+func (e *Explorer) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	limit := explorerDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeExplorerError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if n > explorerMaxLimit {
+			n = explorerMaxLimit
+		}
+		limit = n
+	}
+
+	bci := e.bc.Iterator()
+	if s := r.URL.Query().Get("from"); s != "" {
+		from, err := hex.DecodeString(s)
+		if err != nil || !e.bc.HasBlock(from) {
+			writeExplorerError(w, http.StatusNotFound, "Block is not found")
+			return
+		}
+		bci.currentHash = from
+	}
+
+	blocks := []blockSummaryJSON{}
+	for bci.HasNext() && len(blocks) < limit {
+		block := bci.Next()
+		blocks = append(blocks, blockSummaryJSON{
+			Hash:          hex.EncodeToString(block.Hash),
+			PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+			Height:        block.Height,
+			Timestamp:     block.Timestamp,
+			Bits:          fmt.Sprintf("%08x", block.Bits),
+			TxCount:       len(block.Transactions),
+		})
+	}
+
+	writeExplorerJSON(w, blocks)
+}
+
+func (e *Explorer) handleBlock(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/blocks/"))
+	if err != nil {
+		writeExplorerError(w, http.StatusBadRequest, "Invalid block hash")
+		return
+	}
+
+	block, err := e.bc.GetBlock(hash)
+	if err != nil {
+		writeExplorerError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeExplorerJSON(w, newBlockJSON(&block))
+}
+
+func (e *Explorer) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	ID, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/tx/"))
+	if err != nil {
+		writeExplorerError(w, http.StatusBadRequest, "Invalid transaction ID")
+		return
+	}
+
+	tx, err := e.bc.FindTransaction(ID)
+	if err != nil {
+		writeExplorerError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeExplorerJSON(w, newTransactionJSON(&tx))
+}
+
+// handleAddress 返回地址的余额与未使用输出，以及链上与地址相关的交易，从新到旧排列
+func (e *Explorer) handleAddress(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, "/address/")
+	if !ValidateAddress(address) {
+		writeExplorerError(w, http.StatusBadRequest, "Invalid address")
+		return
+	}
+	result := addressJSON{
+		Address:      address,
+		UTXOs:        []txOutputJSON{},
+		Transactions: []string{},
+	}
+
+	UTXOSet := UTXOSet{e.bc}
+	for _, out := range UTXOSet.FindUTXO(addressToScript(address)) {
+		result.Balance += out.Value
+		result.UTXOs = append(result.UTXOs, newTXOutputJSON(&out))
+	}
+
+	bci := e.bc.Iterator()
+	for bci.HasNext() {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if transactionUsesAddress(tx, address) {
+				result.Transactions = append(result.Transactions, hex.EncodeToString(tx.ID))
+			}
+		}
+	}
+
+	writeExplorerJSON(w, result)
+}
+
+// transactionUsesAddress 交易的输入或输出是否属于address
+// 输入解锁脚本最后压入的公钥或赎回脚本的hash与地址中的hash相同时，输入属于该地址
+func transactionUsesAddress(tx *Transaction, address string) bool {
+	scriptPubKey := addressToScript(address)
+	for _, out := range tx.Vout {
+		if bytes.Equal(out.ScriptPubKey, scriptPubKey) {
+			return true
+		}
+	}
+	if tx.IsCoinbase() {
+		return false
+	}
+	hash := addressToPubKeyHash(address)
+	for _, in := range tx.Vin {
+		if in.UsesKey(hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeExplorerJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func writeExplorerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(explorerError{message})
+	if err != nil {
+		log.Println(err)
+	}
+}
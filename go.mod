@@ -2,7 +2,10 @@ module github.com/Ning-Qing/block
 
 go 1.17
 
-require github.com/boltdb/bolt v1.3.1
+require (
+	github.com/boltdb/bolt v1.3.1
+	github.com/tyler-smith/go-bip39 v1.1.0
+)
 
 require (
 	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
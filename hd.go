@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+const (
+	hardenedKeyStart = uint32(0x80000000) // 索引大于等于该值时为强化派生
+	mnemonicBits     = 128                // 助记词熵的位数，对应12个单词
+)
+
+// hdMasterKeyName 由种子生成主密钥时HMAC-SHA512使用的key
+// 与SLIP-0010中P256曲线的取值相同
+var hdMasterKeyName = []byte("Nist256p1 seed")
+
+// hdAccountPath 派生地址的账户路径 m/44'/coin'/0'/0，地址为其下第i个非强化子密钥
+// coin 为当前网络的HDCoinType
+func hdAccountPath() []uint32 {
+	return []uint32{
+		hardenedKeyStart + 44,
+		hardenedKeyStart + activeNet.HDCoinType,
+		hardenedKeyStart + 0,
+		0,
+	}
+}
+
+var errInvalidChild = errors.New("Invalid child key, use the next index")
+
+// HDKey BIP32风格的扩展密钥
+// PrivateKey 为nil时是扩展公钥，只能进行非强化派生
+type HDKey struct {
+	PrivateKey []byte // 私钥标量D
+	PublicKey  []byte // 公钥，与Wallet.PublicKey格式相同
+	ChainCode  []byte
+}
+
+// NewMnemonic 生成一组新的助记词
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicBits)
+	if err != nil {
+		return "", err
+	}
+
+	return bip39.NewMnemonic(entropy)
+}
+
+// MnemonicToSeed 校验助记词并由其生成64字节的种子
+func MnemonicToSeed(mnemonic string) ([]byte, error) {
+	return bip39.NewSeedWithErrorChecking(mnemonic, "")
+}
+
+// NewMasterKey 由种子生成主扩展密钥
+func NewMasterKey(seed []byte) (*HDKey, error) {
+	mac := hmac.New(sha512.New, hdMasterKeyName)
+	mac.Write(seed)
+	I := mac.Sum(nil)
+
+	d := new(big.Int).SetBytes(I[:32])
+	if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, errors.New("Invalid master key, use another seed")
+	}
+
+	return newPrivateHDKey(d, I[32:]), nil
+}
+
+// Child 派生第index个子密钥
+// index 大于等于hardenedKeyStart时为强化派生，需要私钥
+func (k *HDKey) Child(index uint32) (*HDKey, error) {
+	curve := elliptic.P256()
+	keyLen := (curve.Params().BitSize + 7) / 8
+
+	var data []byte
+	if index >= hardenedKeyStart {
+		if k.PrivateKey == nil {
+			return nil, errors.New("Cannot derive a hardened key from a public key")
+		}
+		data = append([]byte{0x00}, new(big.Int).SetBytes(k.PrivateKey).FillBytes(make([]byte, keyLen))...)
+	} else {
+		x, y := k.point()
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	data = append(data, indexBytes...)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	I := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(I[:32])
+	if il.Cmp(curve.Params().N) >= 0 {
+		return nil, errInvalidChild
+	}
+
+	if k.PrivateKey != nil {
+		// 子私钥 = (IL + 父私钥) mod N
+		d := new(big.Int).Add(il, new(big.Int).SetBytes(k.PrivateKey))
+		d.Mod(d, curve.Params().N)
+		if d.Sign() == 0 {
+			return nil, errInvalidChild
+		}
+
+		return newPrivateHDKey(d, I[32:]), nil
+	}
+
+	// 子公钥 = IL*G + 父公钥
+	x1, y1 := curve.ScalarBaseMult(I[:32])
+	x2, y2 := k.point()
+	x, y := curve.Add(x1, y1, x2, y2)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, errInvalidChild
+	}
+
+	return &HDKey{
+		PublicKey: append(x.FillBytes(make([]byte, keyLen)), y.FillBytes(make([]byte, keyLen))...),
+		ChainCode: I[32:],
+	}, nil
+}
+
+// Derive 按路径依次派生子密钥
+func (k *HDKey) Derive(path []uint32) (*HDKey, error) {
+	key := k
+	for _, index := range path {
+		var err error
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Neuter 返回对应的扩展公钥
+func (k *HDKey) Neuter() *HDKey {
+	return &HDKey{PublicKey: k.PublicKey, ChainCode: k.ChainCode}
+}
+
+// Wallet 返回扩展密钥对应的钱包，扩展公钥返回的钱包不包含私钥
+func (k *HDKey) Wallet() *Wallet {
+	wallet := &Wallet{PublicKey: k.PublicKey}
+	if k.PrivateKey != nil {
+		wallet.PrivateKey = privateKeyFromBytes(k.PrivateKey)
+	}
+
+	return wallet
+}
+
+// point 返回公钥在曲线上的坐标
+func (k *HDKey) point() (*big.Int, *big.Int) {
+	keyLen := len(k.PublicKey)
+	x := new(big.Int).SetBytes(k.PublicKey[:keyLen/2])
+	y := new(big.Int).SetBytes(k.PublicKey[keyLen/2:])
+
+	return x, y
+}
+
+// newPrivateHDKey 由私钥标量与链码创建扩展私钥
+func newPrivateHDKey(d *big.Int, chainCode []byte) *HDKey {
+	privKey := privateKeyFromBytes(d.Bytes())
+	keyLen := (privKey.Curve.Params().BitSize + 7) / 8
+
+	return &HDKey{
+		PrivateKey: d.Bytes(),
+		PublicKey:  publicKeyBytes(&privKey.PublicKey, keyLen),
+		ChainCode:  chainCode,
+	}
+}
+
+// publicKeyBytes 返回补齐到keyLen字节的X、Y拼接而成的公钥
+func publicKeyBytes(pubKey *ecdsa.PublicKey, keyLen int) []byte {
+	return append(pubKey.X.FillBytes(make([]byte, keyLen)), pubKey.Y.FillBytes(make([]byte, keyLen))...)
+}
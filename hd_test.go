@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// mustDecodeHex 解码测试数据中的hex字符串
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// SLIP-0010 NIST P-256 测试向量1
+func TestHDKeyDerivationVectors(t *testing.T) {
+	seed := mustDecodeHex(t, "000102030405060708090a0b0c0d0e0f")
+
+	tests := []struct {
+		path       []uint32
+		chainCode  string
+		privateKey string
+		publicKey  string // 压缩格式
+	}{
+		{
+			nil,
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8",
+		},
+		{
+			[]uint32{hardenedKeyStart + 0},
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c",
+		},
+		{
+			[]uint32{hardenedKeyStart + 0, 1},
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+			"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844",
+		},
+		{
+			[]uint32{hardenedKeyStart + 0, 1, hardenedKeyStart + 2},
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+			"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0",
+		},
+		{
+			[]uint32{hardenedKeyStart + 0, 1, hardenedKeyStart + 2, 2},
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+			"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20",
+		},
+		{
+			[]uint32{hardenedKeyStart + 0, 1, hardenedKeyStart + 2, 2, 1000000000},
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4",
+		},
+	}
+
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		key, err := master.Derive(tt.path)
+		if err != nil {
+			t.Fatalf("Derive(%v): %s", tt.path, err)
+		}
+
+		if got := hex.EncodeToString(key.ChainCode); got != tt.chainCode {
+			t.Errorf("%v: chain code = %s, want %s", tt.path, got, tt.chainCode)
+		}
+		d := new(big.Int).SetBytes(key.PrivateKey).FillBytes(make([]byte, 32))
+		if got := hex.EncodeToString(d); got != tt.privateKey {
+			t.Errorf("%v: private key = %s, want %s", tt.path, got, tt.privateKey)
+		}
+		x, y := key.point()
+		if got := hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), x, y)); got != tt.publicKey {
+			t.Errorf("%v: public key = %s, want %s", tt.path, got, tt.publicKey)
+		}
+	}
+}
+
+// 扩展公钥的非强化派生与扩展私钥派生出的公钥相同
+func TestHDKeyPublicDerivation(t *testing.T) {
+	master, err := NewMasterKey(mustDecodeHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := master.Derive([]uint32{hardenedKeyStart + 0, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []uint32{0, 1, 2, 1000000000} {
+		priv, err := account.Child(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := account.Neuter().Child(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(pub.PublicKey, priv.PublicKey) || !bytes.Equal(pub.ChainCode, priv.ChainCode) {
+			t.Errorf("child %d: public derivation does not match private derivation", index)
+		}
+		if pub.PrivateKey != nil {
+			t.Errorf("child %d: public derivation has a private key", index)
+		}
+	}
+
+	if _, err := account.Neuter().Child(hardenedKeyStart); err == nil {
+		t.Error("hardened derivation from a public key succeeded")
+	}
+}
+
+// BIP39 测试向量，口令为空
+func TestMnemonicToSeed(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	want := "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+
+	seed, err := MnemonicToSeed(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(seed); got != want {
+		t.Errorf("seed = %s, want %s", got, want)
+	}
+
+	if _, err := MnemonicToSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err == nil {
+		t.Error("mnemonic with invalid checksum is accepted")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"log"
+)
+
+// 规范二进制格式之前，交易与区块都使用gob编码存储，交易的hash为gob编码的sha256
+// 旧版本(Version为0)的交易ID与签名都是按gob编码计算的，需要保持计算结果不变
+
+func init() {
+	// gob 的类型ID在进程内按类型首次编码的顺序分配，并写入编码结果中
+	// 启动时先编码一次交易，保证与旧版本的进程计算出的交易hash一致
+	legacyTxHash(&Transaction{})
+}
+
+// legacyTxHash 按gob编码计算旧版本交易的hash
+// gob编码包含类型名与字段名，这里冻结了当时的类型定义，之后修改Transaction不影响旧交易的hash
+func legacyTxHash(tx *Transaction) []byte {
+	type TXInput struct {
+		Txid      []byte
+		Vout      int
+		ScriptSig []byte
+		Sequence  uint32
+	}
+	type TXOutput struct {
+		Value        int
+		ScriptPubKey []byte
+	}
+	type Transaction struct {
+		ID       []byte
+		Vin      []TXInput
+		Vout     []TXOutput
+		LockTime uint32
+	}
+
+	legacy := Transaction{ID: tx.ID, LockTime: tx.LockTime}
+	for _, vin := range tx.Vin {
+		legacy.Vin = append(legacy.Vin, TXInput(vin))
+	}
+	for _, vout := range tx.Vout {
+		legacy.Vout = append(legacy.Vout, TXOutput(vout))
+	}
+
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(legacy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
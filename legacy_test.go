@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestLegacyTxID 旧版本交易的ID必须与gob编码时期计算的ID相同，数据来自格式转换之前的主网db
+func TestLegacyTxID(t *testing.T) {
+	tx := Transaction{
+		Version: txVersionLegacy,
+		Vin: []TXInput{{
+			Txid:      mustDecodeHex(t, "75c702446ac8192968f3fb42bedee80c45a02ae9f1f7764e984937079a447e7d"),
+			Vout:      0,
+			ScriptSig: []byte{0x40}, // 解锁脚本不影响交易ID
+		}},
+		Vout: []TXOutput{
+			{Value: 1, ScriptPubKey: mustDecodeHex(t, "76a914bd8984191976b82071e12051b201a518f1ed8c5a88ac")},
+			{Value: 9, ScriptPubKey: mustDecodeHex(t, "76a9140ce2205dbbf2fd8dc48722d2ef4f89b820789c9c88ac")},
+		},
+		LockTime: 5,
+	}
+
+	want := "d5a2313bff70bc2792aec5e45cbee7c45961c2c3ba0dc561f4ae41a312596329"
+	if got := hex.EncodeToString(tx.CalcID()); got != want {
+		t.Errorf("CalcID() = %s, want %s", got, want)
+	}
+
+	// 相同内容的当前版本交易使用规范格式计算ID
+	tx.Version = txVersion
+	if got := hex.EncodeToString(tx.CalcID()); got == want {
+		t.Error("CalcID() of a current version transaction uses the legacy hash")
+	}
+}
+
+// TestLegacySignatureHash 旧版本交易的签名数据不包含金额，当前版本的包含
+func TestLegacySignatureHash(t *testing.T) {
+	tx := Transaction{
+		Version: txVersionLegacy,
+		Vin:     []TXInput{{Txid: make([]byte, 32), Vout: 0}},
+		Vout:    []TXOutput{{Value: 1, ScriptPubKey: NewP2PKHScript(make([]byte, 20))}},
+	}
+	prevOuts := []TXOutput{{Value: 5, ScriptPubKey: NewP2PKHScript(make([]byte, 20))}}
+	changed := []TXOutput{{Value: 6, ScriptPubKey: prevOuts[0].ScriptPubKey}}
+
+	if !bytes.Equal(tx.SignatureHash(0, prevOuts), tx.SignatureHash(0, changed)) {
+		t.Error("SignatureHash() of a legacy transaction depends on the spent values")
+	}
+
+	tx.Version = txVersion
+	if bytes.Equal(tx.SignatureHash(0, prevOuts), tx.SignatureHash(0, changed)) {
+		t.Error("SignatureHash() of a current version transaction does not commit to the spent values")
+	}
+}
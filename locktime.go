@@ -0,0 +1,94 @@
+package main
+
+import "fmt"
+
+const (
+	lockTimeThreshold = 500000000 // LockTime小于该值时表示区块高度，否则表示Unix时间戳
+
+	maxSequence                 = 0xffffffff // 所有输入的Sequence都为该值时不检查LockTime
+	sequenceLockTimeDisableFlag = 1 << 31    // Sequence设置该位时不检查输入的相对锁定时间
+	sequenceLockTimeTypeFlag    = 1 << 22    // 设置该位时相对锁定时间以512秒为单位，否则以区块数为单位
+	sequenceLockTimeMask        = 0x0000ffff // Sequence的低16位为相对锁定时间
+	sequenceLockTimeGranularity = 9          // 2^9 = 512秒
+)
+
+// confirmation 输出所在区块的高度与时间戳，以及输出是否属于coinbase交易
+type confirmation struct {
+	Height    int
+	Timestamp int64
+	Coinbase  bool
+}
+
+// SetLockTime 设置交易的LockTime与所有输入的Sequence，并重新计算交易ID
+// 需要在签名之前调用
+func (tx *Transaction) SetLockTime(lockTime, sequence uint32) {
+	tx.LockTime = lockTime
+	for i := range tx.Vin {
+		tx.Vin[i].Sequence = sequence
+	}
+	tx.ID = tx.CalcID()
+}
+
+// IsFinal 交易是否可以被打包进高度为height、时间戳为timestamp的区块
+// LockTime为0或所有输入的Sequence都为maxSequence时不限制，否则区块的高度(或时间戳)不能小于LockTime
+func (tx *Transaction) IsFinal(height int, timestamp int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < lockTimeThreshold {
+		if int64(height) >= int64(tx.LockTime) {
+			return true
+		}
+	} else if timestamp >= int64(tx.LockTime) {
+		return true
+	}
+
+	for _, vin := range tx.Vin {
+		if vin.Sequence != maxSequence {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckSequenceLocks 检查交易输入的相对锁定时间
+// prevConfirmations 与交易的输入一一对应，为所引用的输出所在区块的高度与时间戳
+// 输入的Sequence未设置sequenceLockTimeDisableFlag时，区块与所引用输出所在区块的高度差(或时间差)不能小于Sequence中的值
+func (tx *Transaction) CheckSequenceLocks(height int, timestamp int64, prevConfirmations []confirmation) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	for inID, vin := range tx.Vin {
+		if vin.Sequence&sequenceLockTimeDisableFlag != 0 {
+			continue
+		}
+
+		value := int64(vin.Sequence & sequenceLockTimeMask)
+		prev := prevConfirmations[inID]
+		if vin.Sequence&sequenceLockTimeTypeFlag != 0 {
+			unlockTime := prev.Timestamp + value<<sequenceLockTimeGranularity
+			if timestamp < unlockTime {
+				return fmt.Errorf("Input %d is locked until time %d", inID, unlockTime)
+			}
+		} else {
+			unlockHeight := int64(prev.Height) + value
+			if int64(height) < unlockHeight {
+				return fmt.Errorf("Input %d is locked until height %d", inID, unlockHeight)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckLocks 检查交易在高度为height、时间戳为timestamp的区块中是否满足LockTime与所有输入的相对锁定时间
+func (tx *Transaction) CheckLocks(height int, timestamp int64, prevConfirmations []confirmation) error {
+	if !tx.IsFinal(height, timestamp) {
+		return fmt.Errorf("Transaction is locked until %d", tx.LockTime)
+	}
+
+	return tx.CheckSequenceLocks(height, timestamp, prevConfirmations)
+}
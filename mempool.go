@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	mempoolBucket = "mempool"
+	maxTxSize     = maxBlockSize / 10 // 交易池接受的交易序列化之后的大小上限，保证每个交易都能放入区块
+)
+
+// Mempool 等待打包的交易池
+// 只接受验证通过且输入均未被使用的交易，db不为nil时交易同时持久化到mempool bucket中
+type Mempool struct {
+	txs   map[string]Transaction
+	fees  map[string]int
+	spent map[string]string // 被池中交易引用的输出 "txid:vout" -> 引用它的交易ID
+	db    *bolt.DB
+}
+
+// NewMempool 创建交易池
+// db不为nil时从db中加载交易，并丢弃已经失效的交易
+func NewMempool(UTXOSet *UTXOSet, db *bolt.DB) *Mempool {
+	m := &Mempool{
+		txs:   make(map[string]Transaction),
+		fees:  make(map[string]int),
+		spent: make(map[string]string),
+		db:    db,
+	}
+
+	if db == nil {
+		return m
+	}
+
+	var stored []Transaction
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			stored = append(stored, DeserializeTransaction(v))
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, tx := range stored {
+		err := m.Add(tx, UTXOSet)
+		if err != nil {
+			log.Printf("Dropped transaction %x from mempool: %s\n", tx.ID, err)
+			m.deleteStored(tx.ID)
+		}
+	}
+
+	return m
+}
+
+// Add 验证交易并加入交易池
+// 交易的输入必须在UTXO集中且未被池中的其他交易引用，不能使用未成熟的coinbase输出
+// 锁定时间还未满足的交易同样加入交易池，满足之后才会被打包
+func (m *Mempool) Add(tx Transaction, UTXOSet *UTXOSet) error {
+	txID := hex.EncodeToString(tx.ID)
+
+	if tx.IsCoinbase() {
+		return errors.New("Coinbase transaction is not allowed in mempool")
+	}
+	if _, ok := m.txs[txID]; ok {
+		return nil
+	}
+	if err := tx.checkVersion(); err != nil {
+		return err
+	}
+	if !bytes.Equal(tx.ID, tx.CalcID()) {
+		return errors.New("Incorrect transaction ID")
+	}
+	if len(tx.Serialize()) > maxTxSize {
+		return errors.New("Transaction is too large")
+	}
+
+	for _, vin := range tx.Vin {
+		if _, ok := UTXOSet.FindOutput(vin.Txid, vin.Vout); !ok {
+			return fmt.Errorf("Input %x:%d is already spent or does not exist", vin.Txid, vin.Vout)
+		}
+		if other, ok := m.spent[outpointKey(vin.Txid, vin.Vout)]; ok {
+			return fmt.Errorf("Input %x:%d is already spent by pending transaction %s", vin.Txid, vin.Vout, other)
+		}
+	}
+
+	if !UTXOSet.Blockchain.VerifyTransaction(&tx) {
+		return errors.New("Invalid transaction")
+	}
+
+	confirmations, err := UTXOSet.FindConfirmations(&tx)
+	if err != nil {
+		return err
+	}
+	nextHeight := UTXOSet.Blockchain.GetBestHeight() + 1
+	for inID, c := range confirmations {
+		if !c.IsMature(nextHeight) {
+			return fmt.Errorf("Input %d spends immature coinbase output", inID)
+		}
+	}
+
+	m.txs[txID] = tx
+	m.fees[txID] = UTXOSet.Blockchain.TransactionFee(&tx)
+	for _, vin := range tx.Vin {
+		m.spent[outpointKey(vin.Txid, vin.Vout)] = txID
+	}
+
+	if m.db != nil {
+		err := m.db.Update(func(dbtx *bolt.Tx) error {
+			b, err := dbtx.CreateBucketIfNotExists([]byte(mempoolBucket))
+			if err != nil {
+				return err
+			}
+			return b.Put(tx.ID, tx.Serialize())
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	return nil
+}
+
+// Has 判断交易是否在池中
+func (m *Mempool) Has(ID []byte) bool {
+	_, ok := m.txs[hex.EncodeToString(ID)]
+	return ok
+}
+
+// Get 通过ID获取池中的交易
+func (m *Mempool) Get(ID []byte) (Transaction, bool) {
+	tx, ok := m.txs[hex.EncodeToString(ID)]
+	return tx, ok
+}
+
+// Count 返回池中的交易数
+func (m *Mempool) Count() int {
+	return len(m.txs)
+}
+
+// IsSpent 判断输出是否已经被池中的交易引用
+func (m *Mempool) IsSpent(txid []byte, vout int) bool {
+	_, ok := m.spent[outpointKey(txid, vout)]
+	return ok
+}
+
+// Transactions 返回最多max个交易用于打包，max<=0时返回全部
+// 手续费高的交易优先，跳过锁定时间在下一个区块中还未满足的交易，并从池中移除已经无法通过验证的交易
+func (m *Mempool) Transactions(max int, UTXOSet *UTXOSet) []*Transaction {
+	var ids []string
+
+	for id, tx := range m.txs {
+		if !UTXOSet.Blockchain.VerifyTransaction(&tx) {
+			log.Printf("Dropped invalid transaction %s from mempool\n", id)
+			m.remove(id)
+			continue
+		}
+		if checkNextBlockLocks(&tx, UTXOSet) != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if m.fees[ids[i]] != m.fees[ids[j]] {
+			return m.fees[ids[i]] > m.fees[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	if max > 0 && len(ids) > max {
+		ids = ids[:max]
+	}
+
+	var txs []*Transaction
+	for _, id := range ids {
+		tx := m.txs[id]
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+// RemoveBlockTransactions 移除已经被打包进区块的交易，以及与区块中交易引用同一输出的交易
+func (m *Mempool) RemoveBlockTransactions(block *Block) {
+	for _, tx := range block.Transactions {
+		m.remove(hex.EncodeToString(tx.ID))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if other, ok := m.spent[outpointKey(vin.Txid, vin.Vout)]; ok {
+				m.remove(other)
+			}
+		}
+	}
+}
+
+// Revalidate 移除输入已经不在UTXO集中的交易，用于链重组之后
+func (m *Mempool) Revalidate(UTXOSet *UTXOSet) {
+	for txID, tx := range m.txs {
+		for _, vin := range tx.Vin {
+			if _, ok := UTXOSet.FindOutput(vin.Txid, vin.Vout); !ok {
+				m.remove(txID)
+				break
+			}
+		}
+	}
+}
+
+// checkNextBlockLocks 检查交易的锁定时间在下一个区块中是否满足
+// 下一个区块的时间戳使用当前时间
+func checkNextBlockLocks(tx *Transaction, UTXOSet *UTXOSet) error {
+	confirmations, err := UTXOSet.FindConfirmations(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.CheckLocks(UTXOSet.Blockchain.GetBestHeight()+1, clock(), confirmations)
+}
+
+// remove 从池中移除交易
+func (m *Mempool) remove(txID string) {
+	tx, ok := m.txs[txID]
+	if !ok {
+		return
+	}
+
+	for _, vin := range tx.Vin {
+		key := outpointKey(vin.Txid, vin.Vout)
+		if m.spent[key] == txID {
+			delete(m.spent, key)
+		}
+	}
+	delete(m.txs, txID)
+	delete(m.fees, txID)
+
+	m.deleteStored(tx.ID)
+}
+
+// deleteStored 从db中删除交易
+func (m *Mempool) deleteStored(ID []byte) {
+	if m.db == nil {
+		return
+	}
+
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(mempoolBucket))
+		if b == nil || b.Get(ID) == nil {
+			return nil
+		}
+		return b.Delete(ID)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// outpointKey 返回输出的唯一标识
+func outpointKey(txid []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txid, vout)
+}
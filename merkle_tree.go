@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// MerkleTree 默克尔树
+// 叶子节点为交易ID的hash，每一层节点数为奇数时复制最后一个节点
+type MerkleTree struct {
+	RootNode *MerkleNode
+	levels   [][]*MerkleNode // 从叶子层到根的每一层节点，用于生成证明
+}
+
+// MerkleNode 默克尔树节点
+type MerkleNode struct {
+	Left  *MerkleNode
+	Right *MerkleNode
+	Data  []byte // 节点的hash
+}
+
+// MerkleProof 交易的默克尔包含证明
+// Hashes 为从叶子到根路径上每一层的兄弟节点hash
+// Index 为叶子的位置，其二进制的每一位表示对应层的节点是左节点(0)还是右节点(1)
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
+}
+
+// NewMerkleNode 创建一个默克尔树节点
+// 叶子节点对数据做hash，非叶子节点对左右子节点hash的拼接做hash
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
+	node := MerkleNode{}
+
+	if left == nil && right == nil {
+		hash := sha256.Sum256(data)
+		node.Data = hash[:]
+	} else {
+		hash := sha256.Sum256(append(append([]byte{}, left.Data...), right.Data...))
+		node.Data = hash[:]
+	}
+
+	node.Left = left
+	node.Right = right
+
+	return &node
+}
+
+// NewMerkleTree 通过数据集合创建默克尔树
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
+
+	for _, datum := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, []byte{}))
+	}
+
+	tree := MerkleTree{}
+	tree.levels = append(tree.levels, nodes)
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		var level []*MerkleNode
+		for i := 0; i < len(nodes); i += 2 {
+			level = append(level, NewMerkleNode(nodes[i], nodes[i+1], nil))
+		}
+
+		tree.levels = append(tree.levels, level)
+		nodes = level
+	}
+
+	tree.RootNode = nodes[0]
+
+	return &tree
+}
+
+// Proof 生成第index个叶子的包含证明
+func (t *MerkleTree) Proof(index int) *MerkleProof {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil
+	}
+
+	proof := &MerkleProof{Index: index}
+	idx := index
+
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := idx ^ 1
+		// 奇数个节点时，最后一个节点与自身配对
+		if sibling >= len(level) {
+			sibling = idx
+		}
+		proof.Hashes = append(proof.Hashes, level[sibling].Data)
+		idx /= 2
+	}
+
+	return proof
+}
+
+// VerifyMerkleProof 验证交易ID data是否包含在以root为根的默克尔树中
+// 叶子与中间节点的hash方式相同，两个子节点hash拼接成的64字节数据配合截短的证明也能通过验证，所以data必须是32字节的交易ID
+func VerifyMerkleProof(root, data []byte, proof *MerkleProof) bool {
+	if proof == nil || len(data) != sha256.Size {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	current := hash[:]
+	idx := proof.Index
+
+	for _, sibling := range proof.Hashes {
+		var h [32]byte
+		if idx%2 == 0 {
+			h = sha256.Sum256(append(append([]byte{}, current...), sibling...))
+		} else {
+			h = sha256.Sum256(append(append([]byte{}, sibling...), current...))
+		}
+		current = h[:]
+		idx /= 2
+	}
+	if idx != 0 {
+		return false
+	}
+
+	return bytes.Equal(current, root)
+}
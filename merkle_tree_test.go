@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// merkleLeaves 生成n个不同的32字节交易ID
+func merkleLeaves(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("tx%d", i)))
+		data = append(data, hash[:])
+	}
+	return data
+}
+
+func sha256Concat(a, b []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, a...), b...))
+	return hash[:]
+}
+
+func TestMerkleTreeOddLeavesRoot(t *testing.T) {
+	data := merkleLeaves(3)
+	var leaves [][]byte
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		leaves = append(leaves, hash[:])
+	}
+
+	// 第三个叶子与自身配对
+	want := sha256Concat(sha256Concat(leaves[0], leaves[1]), sha256Concat(leaves[2], leaves[2]))
+	if got := NewMerkleTree(data).RootNode.Data; !bytes.Equal(got, want) {
+		t.Errorf("root = %x, want %x", got, want)
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := merkleLeaves(n)
+		tree := NewMerkleTree(data)
+		root := tree.RootNode.Data
+
+		for i := range data {
+			proof := tree.Proof(i)
+			if !VerifyMerkleProof(root, data[i], proof) {
+				t.Errorf("%d leaves: proof of leaf %d is rejected", n, i)
+			}
+			if VerifyMerkleProof(root, merkleLeaves(n + 1)[n], proof) {
+				t.Errorf("%d leaves: proof of leaf %d accepts other data", n, i)
+			}
+			if n > 1 {
+				wrong := &MerkleProof{Index: (i + 1) % n, Hashes: proof.Hashes}
+				if VerifyMerkleProof(root, data[i], wrong) {
+					t.Errorf("%d leaves: proof of leaf %d accepts index %d", n, i, wrong.Index)
+				}
+			}
+		}
+
+		if tree.Proof(-1) != nil || tree.Proof(n) != nil {
+			t.Errorf("%d leaves: proof out of range is not nil", n)
+		}
+	}
+}
+
+func TestVerifyMerkleProofRejectsLargeIndex(t *testing.T) {
+	data := merkleLeaves(3)
+	tree := NewMerkleTree(data)
+	proof := tree.Proof(2)
+
+	// 路径之外多出的位不能被忽略
+	proof.Index += 1 << uint(len(proof.Hashes))
+	if VerifyMerkleProof(tree.RootNode.Data, data[2], proof) {
+		t.Error("proof with index out of the tree is accepted")
+	}
+	if VerifyMerkleProof(tree.RootNode.Data, data[2], nil) {
+		t.Error("nil proof is accepted")
+	}
+}
+
+// 两个子节点hash拼接成的64字节数据不是交易ID，不能作为叶子通过验证
+func TestVerifyMerkleProofRejectsInnerNode(t *testing.T) {
+	data := merkleLeaves(4)
+	tree := NewMerkleTree(data)
+	proof := tree.Proof(0)
+
+	inner := append(append([]byte{}, tree.levels[0][0].Data...), tree.levels[0][1].Data...)
+	shortened := &MerkleProof{Index: 0, Hashes: proof.Hashes[1:]}
+	if VerifyMerkleProof(tree.RootNode.Data, inner, shortened) {
+		t.Error("concatenated child hashes are accepted as a leaf")
+	}
+}
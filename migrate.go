@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	metaBucket = "meta"
+	formatKey  = "format"
+
+	// dbFormat block.db中区块与mempool交易的存储格式
+	// 0: gob编码，没有meta bucket的db都是这个格式
+	// 1: 规范二进制格式，见serialize.go
+	dbFormat = 1
+)
+
+// migrateDB 将旧格式的db中的区块转换为当前的格式，并丢弃交易池中的旧版本交易，在打开db的事务中调用
+// 转换之前的交易Version为0，仍按gob编码计算交易ID与签名，所以区块hash与交易ID都不变
+// chainstate与undo数据只在本地使用，类型没有改变，保持gob编码
+func migrateDB(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+	if format := meta.Get([]byte(formatKey)); format != nil {
+		if int(format[0]) > dbFormat {
+			return fmt.Errorf("Database format %d is newer than supported format %d", format[0], dbFormat)
+		}
+		return nil
+	}
+
+	blocks := 0
+	if b := tx.Bucket([]byte(blocksBucket)); b != nil {
+		updates := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, []byte("l")) {
+				return nil
+			}
+
+			var block Block
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&block)
+			if err != nil {
+				return fmt.Errorf("Block %x cannot be decoded: %s", k, err)
+			}
+			updates[string(k)] = block.Serialize()
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// 遍历时不能修改bucket
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		blocks = len(updates)
+	}
+
+	// 转换之前的链的创世区块包含旧版本交易，与当前网络的创世区块不同，删除记录之后作为旧链继续使用
+	if err := meta.Delete([]byte(genesisKey)); err != nil {
+		return err
+	}
+
+	// 交易池中的旧版本交易不能再被打包，直接丢弃
+	txs := 0
+	if b := tx.Bucket([]byte(mempoolBucket)); b != nil {
+		txs = b.Stats().KeyN
+		if err := tx.DeleteBucket([]byte(mempoolBucket)); err != nil {
+			return err
+		}
+	}
+
+	if blocks > 0 {
+		log.Printf("Migrated %d blocks to format %d\n", blocks, dbFormat)
+	}
+	if txs > 0 {
+		log.Printf("Dropped %d legacy mempool transactions\n", txs)
+	}
+
+	return meta.Put([]byte(formatKey), []byte{dbFormat})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// TestMigrateFormat0 打开转换格式之前创建的regtest db
+// testdata/format0-regtest.db 由转换格式之前的版本生成: 14个区块，其中一笔已确认的转账，交易池中一笔交易
+func TestMigrateFormat0(t *testing.T) {
+	defer func(net *ChainParams, dir string, engine ConsensusEngine, now int64) {
+		activeNet = net
+		dataDir = dir
+		activeEngine = engine
+		mockTime = now
+	}(activeNet, dataDir, activeEngine, mockTime)
+
+	activeNet = &regTestParams
+	dataDir = t.TempDir()
+	ensureDataDir()
+
+	// 复制一份再打开，转换格式不修改testdata中的文件
+	data, err := ioutil.ReadFile("testdata/format0-regtest.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dataFilePath(dbFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := NewBlockchain("")
+	defer bc.db.Close()
+
+	if got := bc.GetBestHeight(); got != 13 {
+		t.Errorf("GetBestHeight() = %d, want 13", got)
+	}
+
+	// 旧版本交易的ID与签名在转换之后仍然有效
+	if _, err := bc.VerifyChain(3, 0); err != nil {
+		t.Errorf("VerifyChain() error = %v", err)
+	}
+
+	spends := 0
+	bci := bc.Iterator()
+	for {
+		block := bci.Next()
+		for _, tx := range block.Transactions {
+			if tx.Version != txVersionLegacy {
+				t.Errorf("transaction %x: Version = %d, want %d", tx.ID, tx.Version, txVersionLegacy)
+			}
+			if !tx.IsCoinbase() {
+				spends++
+			}
+		}
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	if spends != 1 {
+		t.Errorf("found %d spending transactions, want 1", spends)
+	}
+
+	err = bc.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if format := meta.Get([]byte(formatKey)); len(format) != 1 || format[0] != dbFormat {
+			t.Errorf("format = %v, want %d", format, dbFormat)
+		}
+		if genesis := meta.Get([]byte(genesisKey)); genesis != nil {
+			t.Errorf("genesis = %x, want no record for a chain created before the fixed genesis block", genesis)
+		}
+		if b := tx.Bucket([]byte(mempoolBucket)); b != nil && b.Stats().KeyN != 0 {
+			t.Errorf("mempool has %d transactions after migration, want 0", b.Stats().KeyN)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// MultisigAddress 返回多重签名赎回脚本对应的P2SH地址
+func MultisigAddress(redeemScript []byte) string {
+	return scriptHashToAddress(HashPubKey(redeemScript))
+}
+
+// NewMultisigTransaction 创建一个从多重签名地址支付给to的未签名交易，找零支付给多重签名地址
+func NewMultisigTransaction(redeemScript []byte, to string, amount, fee int, UTXOSet *UTXOSet, mempool *Mempool) (*PartialTransaction, error) {
+	_, _, err := ParseMultisigScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := NewUnsignedTransaction(MultisigAddress(redeemScript), to, amount, fee, UTXOSet, mempool)
+
+	return newPartialTransaction(tx, redeemScript, UTXOSet)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMultisigScript(t *testing.T) {
+	var pubKeys [][]byte
+	for i := 0; i < maxMultisigKeys+1; i++ {
+		pubKeys = append(pubKeys, NewWallet().PublicKey)
+	}
+
+	tests := []struct {
+		name    string
+		m       int
+		keys    [][]byte
+		wantErr bool
+	}{
+		{"1-of-1", 1, pubKeys[:1], false},
+		{"2-of-3", 2, pubKeys[:3], false},
+		{"3-of-3", 3, pubKeys[:3], false},
+		{"0-of-2", 0, pubKeys[:2], true},
+		{"3-of-2", 3, pubKeys[:2], true},
+		{"no keys", 1, nil, true},
+		{"too many keys", 1, pubKeys, true},
+		// 8个64字节的公钥使脚本超过了压入栈的数据的大小限制
+		{"script too large", 1, pubKeys[:8], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := NewMultisigScript(tt.m, tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMultisigScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			m, keys, err := ParseMultisigScript(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != tt.m || len(keys) != len(tt.keys) {
+				t.Fatalf("ParseMultisigScript() = %d-of-%d, want %d-of-%d", m, len(keys), tt.m, len(tt.keys))
+			}
+			for i := range keys {
+				if !bytes.Equal(keys[i], tt.keys[i]) {
+					t.Errorf("public key %d does not match", i)
+				}
+			}
+
+			address := MultisigAddress(script)
+			if !ValidateAddress(address) {
+				t.Fatalf("MultisigAddress() = %s is not valid", address)
+			}
+			scriptHash, ok := ExtractScriptHash(addressToScript(address))
+			if !ok || !bytes.Equal(scriptHash, HashPubKey(script)) {
+				t.Errorf("MultisigAddress() = %s does not lock to the script hash", address)
+			}
+		})
+	}
+}
+
+func TestParseMultisigScriptRejectsOtherScripts(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+	}{
+		{"empty", nil},
+		{"p2pkh", NewP2PKHScript(make([]byte, 20))},
+		{"m greater than n", (&ScriptBuilder{}).AddOp(OP_1 + 1).AddData([]byte{1}).AddOp(OP_1).AddOp(OP_CHECKMULTISIG).Script()},
+		{"n does not match keys", (&ScriptBuilder{}).AddOp(OP_1).AddData([]byte{1}).AddOp(OP_1 + 1).AddOp(OP_CHECKMULTISIG).Script()},
+		{"key is not data", []byte{OP_1, OP_DUP, OP_1, OP_CHECKMULTISIG}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseMultisigScript(tt.script); err == nil {
+				t.Error("ParseMultisigScript() succeeded, want an error")
+			}
+		})
+	}
+}
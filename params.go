@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ChainParams 一个网络的参数，不同网络的区块链、地址与节点互不兼容
+type ChainParams struct {
+	Name string // 网络名，主网以外的网络使用数据目录下同名的子目录
+
+	// 创世区块，所有节点使用相同的创世区块，见NewGenesisBlock
+	GenesisCoinbaseData string // coinbase交易的数据
+	GenesisTimestamp    int64  // 时间戳
+	GenesisNonce        int    // 满足PowLimitBits的nonce
+	GenesisHash         string // 区块hash的hex编码
+
+	// 地址的版本号
+	PubKeyHashAddrID byte   // P2PKH地址
+	ScriptHashAddrID byte   // P2SH地址
+	HDCoinType       uint32 // HD钱包派生路径 m/44'/coin'/0'/0 中的coin
+
+	// 难度
+	PowLimit         *big.Int // 最大的target，即最低的难度，也是创世区块的难度
+	PowLimitBits     uint32   // PowLimit的紧凑格式
+	TargetSpacing    int64    // 期望的出块间隔，单位秒
+	RetargetInterval int      // 每隔多少个区块调整一次难度
+	NoRetargeting    bool     // 为true时所有区块都使用PowLimitBits
+
+	// 挖矿奖励
+	InitialSubsidy         int // 创世区块开始的挖矿奖励
+	SubsidyHalvingInterval int // 每隔多少个区块挖矿奖励减半
+	MaxSupply              int // 挖矿奖励的总量上限
+	CoinbaseMaturity       int // coinbase交易的输出需要确认多少个区块才能使用
+
+	// 默认端口
+	DefaultPort  string // 节点
+	RPCPort      string // JSON-RPC
+	ExplorerPort string // 区块浏览器
+}
+
+// TargetTimespan 一个难度调整周期的期望时长
+func (p *ChainParams) TargetTimespan() int64 {
+	return p.TargetSpacing * int64(p.RetargetInterval)
+}
+
+// DefaultSeed 默认连接的节点
+func (p *ChainParams) DefaultSeed() string {
+	return "localhost:" + p.DefaultPort
+}
+
+// powLimit 返回hash前targetBits位为0的target，targetBits越大难度越大
+func powLimit(targetBits uint) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), 256-targetBits)
+}
+
+var mainNetPowLimit = powLimit(12)
+
+// mainNetParams 主网
+var mainNetParams = ChainParams{
+	Name:                   "mainnet",
+	GenesisCoinbaseData:    "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+	GenesisTimestamp:       1767225600,
+	GenesisNonce:           1374,
+	GenesisHash:            "0007d12f077cad70c9e1662de4b87224413c90750e971203caaf5c81c3edcf2e",
+	PubKeyHashAddrID:       0x00, // 地址以1开头
+	ScriptHashAddrID:       0x05, // 地址以3开头
+	HDCoinType:             0,
+	PowLimit:               mainNetPowLimit,
+	PowLimitBits:           BigToCompact(mainNetPowLimit),
+	TargetSpacing:          10,
+	RetargetInterval:       10,
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 100,
+	MaxSupply:              1750,
+	CoinbaseMaturity:       10,
+	DefaultPort:            "3000",
+	RPCPort:                "8545",
+	ExplorerPort:           "8080",
+}
+
+var testNetPowLimit = powLimit(10)
+
+// testNetParams 测试网，难度较低，奖励规则与主网相同
+var testNetParams = ChainParams{
+	Name:                   "testnet",
+	GenesisCoinbaseData:    "Testnet genesis block",
+	GenesisTimestamp:       1767225600,
+	GenesisNonce:           1423,
+	GenesisHash:            "003c79af9317368944403fce08ecd9751a320d3a953beed63032991e590f7dc6",
+	PubKeyHashAddrID:       0x6f, // 地址以m或n开头
+	ScriptHashAddrID:       0xc4, // 地址以2开头
+	HDCoinType:             1,
+	PowLimit:               testNetPowLimit,
+	PowLimitBits:           BigToCompact(testNetPowLimit),
+	TargetSpacing:          10,
+	RetargetInterval:       10,
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 100,
+	MaxSupply:              1750,
+	CoinbaseMaturity:       10,
+	DefaultPort:            "13000",
+	RPCPort:                "18545",
+	ExplorerPort:           "18080",
+}
+
+var regTestPowLimit = powLimit(1)
+
+// regTestParams 本地回归测试网络，几乎没有难度且不调整难度，可以立即挖出区块
+var regTestParams = ChainParams{
+	Name:                   "regtest",
+	GenesisCoinbaseData:    "Regtest genesis block",
+	GenesisTimestamp:       1700000000,
+	GenesisNonce:           0,
+	GenesisHash:            "184f794dedc77ccf1b96a52c26215e6bd71c4e8d4161a5b2e480a61decb8dec2",
+	PubKeyHashAddrID:       0x3c, // 地址以R开头
+	ScriptHashAddrID:       0x7a, // 地址以r开头
+	HDCoinType:             1,
+	PowLimit:               regTestPowLimit,
+	PowLimitBits:           BigToCompact(regTestPowLimit),
+	TargetSpacing:          10,
+	RetargetInterval:       10,
+	NoRetargeting:          true,
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 150,
+	MaxSupply:              2700,
+	CoinbaseMaturity:       10,
+	DefaultPort:            "23000",
+	RPCPort:                "28545",
+	ExplorerPort:           "28080",
+}
+
+// activeNet 当前使用的网络，由-network选择
+var activeNet = &mainNetParams
+
+// networks 可以通过-network选择的网络
+var networks = []*ChainParams{&mainNetParams, &testNetParams, &regTestParams}
+
+// SelectNetwork 将名为name的网络设置为当前网络
+func SelectNetwork(name string) error {
+	for _, params := range networks {
+		if params.Name == name {
+			activeNet = params
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown network %q", name)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// PartialTransaction 等待签名的交易
+// 文件中保存了签名需要的全部数据，签名方不需要访问区块链，可以离线签名
+// 各方依次在文件中添加签名，签名足够后生成每个输入的解锁脚本
+type PartialTransaction struct {
+	Tx     Transaction
+	Inputs []PartialInput // 与Tx.Vin一一对应
+}
+
+// PartialInput 签名一个输入所需的数据与已经收集到的签名
+type PartialInput struct {
+	PrevOutput   TXOutput          // 输入引用的输出
+	RedeemScript []byte            // P2SH输出的赎回脚本，P2PKH输出为空
+	Signatures   map[string][]byte // hex编码的公钥 -> 签名
+}
+
+// partialTransactionFile 文件中保存的PartialTransaction，字节数组使用hex编码
+type partialTransactionFile struct {
+	Tx     string             `json:"tx"`
+	Inputs []partialInputFile `json:"inputs"`
+}
+
+type partialInputFile struct {
+	Value        int               `json:"value"`
+	ScriptPubKey string            `json:"scriptpubkey"`
+	RedeemScript string            `json:"redeemscript,omitempty"`
+	Signatures   map[string]string `json:"signatures"`
+}
+
+// NewRawTransaction 创建一个从from地址支付给to的未签名交易，找零支付给from
+func NewRawTransaction(from, to string, amount, fee int, UTXOSet *UTXOSet, mempool *Mempool) (*PartialTransaction, error) {
+	tx := NewUnsignedTransaction(from, to, amount, fee, UTXOSet, mempool)
+
+	return newPartialTransaction(tx, nil, UTXOSet)
+}
+
+// newPartialTransaction 从UTXO集中查找交易输入引用的输出
+func newPartialTransaction(tx *Transaction, redeemScript []byte, UTXOSet *UTXOSet) (*PartialTransaction, error) {
+	ptx := &PartialTransaction{Tx: *tx}
+
+	for _, vin := range tx.Vin {
+		prevOut, ok := UTXOSet.FindOutput(vin.Txid, vin.Vout)
+		if !ok {
+			return nil, fmt.Errorf("Output %x:%d is not found", vin.Txid, vin.Vout)
+		}
+		ptx.Inputs = append(ptx.Inputs, PartialInput{
+			PrevOutput:   prevOut,
+			RedeemScript: redeemScript,
+			Signatures:   make(map[string][]byte),
+		})
+	}
+
+	return ptx, nil
+}
+
+// Fee 交易的手续费，即输入引用的输出之和与输出之和的差
+func (ptx *PartialTransaction) Fee() int {
+	fee := 0
+	for _, input := range ptx.Inputs {
+		fee += input.PrevOutput.Value
+	}
+	for _, out := range ptx.Tx.Vout {
+		fee -= out.Value
+	}
+
+	return fee
+}
+
+// Sign 使用钱包为可以由钱包公钥解锁的输入签名，返回签名的输入数
+func (ptx *PartialTransaction) Sign(wallet *Wallet) (int, error) {
+	if wallet.PrivateKey.D == nil {
+		return 0, errors.New("Wallet is locked")
+	}
+
+	var prevOuts []TXOutput
+	for _, input := range ptx.Inputs {
+		prevOuts = append(prevOuts, input.PrevOutput)
+	}
+
+	signed := 0
+	for inID, input := range ptx.Inputs {
+		ok, err := input.hasKey(wallet.PublicKey)
+		if err != nil {
+			return signed, fmt.Errorf("Input %d: %s", inID, err)
+		}
+		if !ok {
+			continue
+		}
+
+		sigHash := ptx.Tx.SignatureHash(inID, prevOuts)
+		input.Signatures[hex.EncodeToString(wallet.PublicKey)] = signHash(wallet.PrivateKey, sigHash)
+		signed++
+	}
+
+	return signed, nil
+}
+
+// hasKey 输入是否需要pubKey对应私钥的签名
+func (in *PartialInput) hasKey(pubKey []byte) (bool, error) {
+	if len(in.RedeemScript) == 0 {
+		pubKeyHash, ok := ExtractPubKeyHash(in.PrevOutput.ScriptPubKey)
+		if !ok {
+			return false, errors.New("Output is neither P2PKH nor P2SH")
+		}
+		return bytes.Equal(pubKeyHash, HashPubKey(pubKey)), nil
+	}
+
+	_, pubKeys, err := ParseMultisigScript(in.RedeemScript)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Finalize 使用收集到的签名生成每个输入的解锁脚本
+// P2PKH输入: <sig> <pubKey>
+// P2SH输入: 按赎回脚本中公钥的顺序取出M个签名 <sig1> ... <sigM> <redeemScript>
+func (ptx *PartialTransaction) Finalize() (*Transaction, error) {
+	tx := ptx.Tx
+	tx.Vin = append([]TXInput{}, ptx.Tx.Vin...)
+
+	for inID, input := range ptx.Inputs {
+		var scriptSig []byte
+		var err error
+		if len(input.RedeemScript) == 0 {
+			scriptSig, err = input.p2pkhScriptSig()
+		} else {
+			scriptSig, err = input.multisigScriptSig()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Input %d: %s", inID, err)
+		}
+
+		tx.Vin[inID].ScriptSig = scriptSig
+	}
+
+	return &tx, nil
+}
+
+func (in *PartialInput) p2pkhScriptSig() ([]byte, error) {
+	for pubKeyHex, signature := range in.Signatures {
+		pubKey, err := hex.DecodeString(pubKeyHex)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := in.hasKey(pubKey); ok {
+			return NewP2PKHScriptSig(signature, pubKey), nil
+		}
+	}
+
+	return nil, errors.New("Input is not signed")
+}
+
+func (in *PartialInput) multisigScriptSig() ([]byte, error) {
+	m, pubKeys, err := ParseMultisigScript(in.RedeemScript)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &ScriptBuilder{}
+	count := 0
+	for _, pubKey := range pubKeys {
+		signature, ok := in.Signatures[hex.EncodeToString(pubKey)]
+		if !ok || count == m {
+			continue
+		}
+		b.AddData(signature)
+		count++
+	}
+	if count < m {
+		return nil, fmt.Errorf("%d of %d required signatures", count, m)
+	}
+	b.AddData(in.RedeemScript)
+
+	return b.Script(), nil
+}
+
+// SaveToFile 将交易以JSON格式保存到文件中
+func (ptx *PartialTransaction) SaveToFile(path string) error {
+	file := partialTransactionFile{Tx: hex.EncodeToString(ptx.Tx.Serialize())}
+
+	for _, input := range ptx.Inputs {
+		signatures := make(map[string]string)
+		for pubKey, signature := range input.Signatures {
+			signatures[pubKey] = hex.EncodeToString(signature)
+		}
+		file.Inputs = append(file.Inputs, partialInputFile{
+			Value:        input.PrevOutput.Value,
+			ScriptPubKey: hex.EncodeToString(input.PrevOutput.ScriptPubKey),
+			RedeemScript: hex.EncodeToString(input.RedeemScript),
+			Signatures:   signatures,
+		})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadPartialTransaction 从文件中加载交易
+func LoadPartialTransaction(path string) (*PartialTransaction, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file partialTransactionFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	txData, err := hex.DecodeString(file.Tx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := decodeTransaction(txData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid transaction: %s", path, err)
+	}
+	ptx := &PartialTransaction{Tx: tx}
+	if len(file.Inputs) != len(ptx.Tx.Vin) {
+		return nil, errors.New("Number of inputs does not match the transaction")
+	}
+
+	for _, in := range file.Inputs {
+		input := PartialInput{
+			PrevOutput: TXOutput{Value: in.Value},
+			Signatures: make(map[string][]byte),
+		}
+		input.PrevOutput.ScriptPubKey, err = hex.DecodeString(in.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		input.RedeemScript, err = hex.DecodeString(in.RedeemScript)
+		if err != nil {
+			return nil, err
+		}
+		for pubKey, signature := range in.Signatures {
+			input.Signatures[pubKey], err = hex.DecodeString(signature)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ptx.Inputs = append(ptx.Inputs, input)
+	}
+
+	return ptx, nil
+}
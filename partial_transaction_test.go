@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// 签名包含输入引用的输出的金额，文件中的金额被篡改时签名无效
+func TestPartialTransactionSignsInputValues(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+
+	prev := NewCoinbaseTX(string(alice.GetAddress()), "", 1, 90)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): *prev}
+	value := prev.Vout[0].Value
+
+	tests := []struct {
+		name      string
+		fileValue int
+		wantFee   int
+		valid     bool
+	}{
+		{"correct value", value, value - 9, true},
+		{"value lowered to hide the fee", 10, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := Transaction{
+				Version: txVersion,
+				Vin:     []TXInput{{Txid: prev.ID, Vout: 0}},
+				Vout:    []TXOutput{*NewTXOutput(9, string(bob.GetAddress()))},
+			}
+			tx.ID = tx.CalcID()
+
+			ptx := &PartialTransaction{
+				Tx: tx,
+				Inputs: []PartialInput{{
+					PrevOutput: TXOutput{Value: tt.fileValue, ScriptPubKey: prev.Vout[0].ScriptPubKey},
+					Signatures: make(map[string][]byte),
+				}},
+			}
+			if fee := ptx.Fee(); fee != tt.wantFee {
+				t.Errorf("Fee() = %d, want %d", fee, tt.wantFee)
+			}
+
+			signed, err := ptx.Sign(alice)
+			if err != nil || signed != 1 {
+				t.Fatalf("Sign() = %d, %v", signed, err)
+			}
+			final, err := ptx.Finalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := final.Verify(prevTXs); got != tt.valid {
+				t.Errorf("Verify() = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// signerPassphraseEnv 钱包加密时，PoA封装区块使用该环境变量中的口令解锁钱包
+// 使用环境变量而不是命令行选项，口令不会出现在节点的进程列表中
+const signerPassphraseEnv = "BLOCK_SIGNER_PASSPHRASE"
+
+// poaEngine 轮流签名的权威证明(Proof-of-Authority)共识，不需要计算工作量证明
+// 高度为h的区块必须由signers[h%len(signers)]签名，签名者的私钥从钱包中获取
+type poaEngine struct {
+	signers []string // 签名者的P2PKH地址，按轮流的顺序排列
+	wallets *Wallets // 第一次封装区块时加载
+}
+
+// NewPoAEngine 创建PoA共识引擎，signers 为轮流签名的P2PKH地址
+func NewPoAEngine(signers []string) (ConsensusEngine, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("PoA needs at least one signer")
+	}
+	for _, signer := range signers {
+		if !ValidateAddress(signer) {
+			return nil, fmt.Errorf("Signer address %s is not valid", signer)
+		}
+		if _, ok := ExtractPubKeyHash(addressToScript(signer)); !ok {
+			return nil, fmt.Errorf("Signer address %s is not a P2PKH address", signer)
+		}
+	}
+
+	return &poaEngine{signers: signers}, nil
+}
+
+// signer 返回高度为height的区块的签名者
+func (e *poaEngine) signer(height int) string {
+	return e.signers[height%len(e.signers)]
+}
+
+// Seal 使用钱包中签名者的私钥对区块hash签名
+// 钱包已加密时使用$BLOCK_SIGNER_PASSPHRASE解锁，之后保持解锁；钱包中没有轮到的签名者或无法解锁时返回错误
+func (e *poaEngine) Seal(ctx context.Context, block *Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if e.wallets == nil {
+		wallets, err := NewWallets()
+		if err != nil {
+			return err
+		}
+		e.wallets = wallets
+	}
+
+	signer := e.signer(block.Height)
+	if _, ok := e.wallets.Wallets[signer]; !ok {
+		return fmt.Errorf("Block at height %d must be signed by %s, which is not in the wallet", block.Height, signer)
+	}
+	if e.wallets.IsLocked() {
+		passphrase := os.Getenv(signerPassphraseEnv)
+		if passphrase == "" {
+			return fmt.Errorf("Wallet is locked, set $%s to its passphrase", signerPassphraseEnv)
+		}
+		if err := e.wallets.Unlock(passphrase, 0); err != nil {
+			return err
+		}
+	}
+	wallet := e.wallets.GetWallet(signer)
+
+	block.Nonce = 0
+	block.Hash = block.CalcHash()
+	block.SignerPubKey = wallet.PublicKey
+	block.Signature = signHash(wallet.PrivateKey, block.Hash)
+
+	log.Printf("Signed block at height %d as %s\n", block.Height, signer)
+	return nil
+}
+
+// VerifySeal 验证区块的难度，以及区块由轮到的签名者签名
+func (e *poaEngine) VerifySeal(bc *Blockchain, block *Block) error {
+	if block.Bits != e.CalcDifficulty(bc, block.PrevBlockHash) {
+		return errors.New("Incorrect block difficulty")
+	}
+
+	signer := e.signer(block.Height)
+	if !bytes.Equal(HashPubKey(block.SignerPubKey), addressToPubKeyHash(signer)) {
+		return fmt.Errorf("Block must be signed by %s", signer)
+	}
+	if !checkSignature(block.Signature, block.SignerPubKey, block.CalcHash()) {
+		return errors.New("Invalid block signature")
+	}
+
+	return nil
+}
+
+// CalcDifficulty PoA不调整难度，所有区块都使用PowLimitBits，每个区块的工作量相同，累计工作量最大的链即最长的链
+func (e *poaEngine) CalcDifficulty(bc *Blockchain, prevHash []byte) uint32 {
+	return activeNet.PowLimitBits
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// 钱包加密时Seal使用环境变量中的口令解锁钱包
+func TestPoASealUnlocksEncryptedWallet(t *testing.T) {
+	defer func(dir string) {
+		dataDir = dir
+	}(dataDir)
+	dataDir = t.TempDir()
+
+	wallets, _ := NewWallets()
+	signer, err := wallets.CreateWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wallets.EncryptWallet("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine := &poaEngine{signers: []string{signer}, wallets: wallets}
+	coinbase := NewCoinbaseTX(signer, "", 1, 0)
+	block := NewBlock([]*Transaction{coinbase}, make([]byte, 32), 1, activeNet.PowLimitBits, 1700000000)
+
+	tests := []struct {
+		passphrase string
+		ok         bool
+	}{
+		{"", false},
+		{"wrong", false},
+		{"correct horse", true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv(signerPassphraseEnv, tt.passphrase)
+		err := engine.Seal(context.Background(), block)
+		if (err == nil) != tt.ok {
+			t.Fatalf("Seal with passphrase %q: err = %v, want ok = %v", tt.passphrase, err, tt.ok)
+		}
+	}
+
+	if !checkSignature(block.Signature, block.SignerPubKey, block.CalcHash()) {
+		t.Error("block signature is not valid")
+	}
+
+	// 解锁之后不再需要口令
+	t.Setenv(signerPassphraseEnv, "")
+	if err := engine.Seal(context.Background(), block); err != nil {
+		t.Errorf("Seal after unlocking: %v", err)
+	}
+}
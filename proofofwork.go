@@ -2,74 +2,250 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// nonceCheckInterval 每计算多少个hash检查一次是否取消
+	nonceCheckInterval = 4096
+	// hashRateInterval 挖矿时每隔多久打印一次算力
+	hashRateInterval = 10 * time.Second
 )
 
 var (
+	// maxNonce nonce的取值范围为[0, maxNonce)，全部不满足难度时使用extra nonce，测试中减小以触发extra nonce
 	maxNonce = math.MaxInt64
+	// miningThreads 工作量证明使用的goroutine数，由-threads设置
+	miningThreads = runtime.NumCPU()
 )
 
-// targetBits 越大难度越大
-const targetBits = 12
+var errNonceExhausted = errors.New("All nonces are tried")
+
+// powEngine Hashcash工作量证明共识
+type powEngine struct{}
+
+// Seal 使用miningThreads个goroutine执行工作量证明，设置区块的Nonce与Hash
+// 所有nonce都不满足难度时增加coinbase交易中的extra nonce并重新计算默克尔树根
+// ctx被取消时返回ctx.Err()
+func (powEngine) Seal(ctx context.Context, b *Block) error {
+	coinbase := b.Transactions[0]
+	coinbaseData := coinbase.Vin[0].ScriptSig
+
+	var hashes uint64
+	start := time.Now()
+	for extraNonce := 0; ; extraNonce++ {
+		if extraNonce > 0 {
+			coinbase.Vin[0].ScriptSig = append(append([]byte{}, coinbaseData...), fmt.Sprintf(" %d", extraNonce)...)
+			coinbase.ID = coinbase.CalcID()
+			b.MerkleRoot = b.HashTransactions()
+		}
+
+		pow := NewProofOfWork(b)
+		nonce, hash, err := pow.Run(ctx, miningThreads)
+		hashes += pow.hashes
+		if err == errNonceExhausted {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		b.Nonce = nonce
+		b.Hash = hash
+		break
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("Mined block at height %d: %d hashes in %s, %.0f hashes/s\n",
+		b.Height, hashes, elapsed.Round(time.Millisecond), float64(hashes)/elapsed.Seconds())
+	return nil
+}
+
+// VerifySeal 验证区块的难度与工作量证明
+func (e powEngine) VerifySeal(bc *Blockchain, block *Block) error {
+	if !NewProofOfWork(block).Validate(e.CalcDifficulty(bc, block.PrevBlockHash)) {
+		return errors.New("Invalid proof of work")
+	}
+
+	return nil
+}
+
+// CalcDifficulty 计算父区块为prevHash的区块应当使用的难度
+// 每RetargetInterval个区块根据上一个周期的出块时间调整一次，其余区块沿用父区块的难度
+func (powEngine) CalcDifficulty(bc *Blockchain, prevHash []byte) uint32 {
+	if len(prevHash) == 0 || activeNet.NoRetargeting {
+		return activeNet.PowLimitBits
+	}
+
+	prev, err := bc.GetBlock(prevHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if (prev.Height+1)%activeNet.RetargetInterval != 0 {
+		return prev.Bits
+	}
+
+	// 调整周期的第一个区块
+	first := prev
+	for i := 0; i < activeNet.RetargetInterval-1; i++ {
+		first, err = bc.GetBlock(first.PrevBlockHash)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	return retarget(prev.Bits, prev.Timestamp-first.Timestamp)
+}
 
 type ProofOfWork struct {
 	block  *Block
 	target *big.Int
+	hashes uint64 // 上一次Run计算的hash数
 }
 
 // NewProofOfWork 创建一个ProofOfWork
+// target 由区块的Bits得出
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := CompactToBig(b.Bits)
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{block: b, target: target}
 	return pow
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
 	data := bytes.Join([][]byte{
+		IntToHex(int64(pow.block.Version)),
 		pow.block.PrevBlockHash,
-		pow.block.HashTransactions(),
+		pow.block.MerkleRoot,
 		IntToHex(pow.block.Timestamp),
-		IntToHex(int64(targetBits)),
+		IntToHex(int64(pow.block.Bits)),
+		IntToHex(int64(pow.block.Height)),
 		IntToHex(int64(nonce)),
 	}, []byte{})
 	return data
 }
 
-// Run 执行工作量证明
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+// Run 执行工作量证明，返回满足target的最小nonce与区块hash
+// nonce空间按threads交错划分给threads个goroutine，ctx被取消时返回ctx.Err()
+// 所有nonce都不满足target时返回errNonceExhausted，需要修改区块内容(extra nonce)之后重新计算
+func (pow *ProofOfWork) Run(ctx context.Context, threads int) (int, []byte, error) {
+	if threads < 1 {
+		threads = 1
+	}
 
-	fmt.Printf("Mining a new block")
+	// 区块头中nonce位于最后，只需要替换最后8个字节
+	header := pow.prepareData(0)
+	prefixLen := len(header) - 8
 
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
+	var (
+		best    = int64(maxNonce) // 已经找到的最小nonce
+		hashes  uint64            // 已经计算的hash数
+		wg      sync.WaitGroup
+		stopped = make(chan struct{})
+	)
 
-		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
+	go pow.reportHashRate(&hashes, stopped)
 
-		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
-		}
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+
+			data := append([]byte{}, header...)
+			var hashInt big.Int
+			var count uint64
+
+			for nonce := start; nonce < maxNonce; nonce += threads {
+				// 每个goroutine按从小到大的顺序尝试，超过已找到的nonce之后不会再找到更小的
+				if int64(nonce) > atomic.LoadInt64(&best) {
+					break
+				}
+				count++
+				if count%nonceCheckInterval == 0 {
+					atomic.AddUint64(&hashes, nonceCheckInterval)
+					if ctx.Err() != nil {
+						break
+					}
+				}
+
+				binary.BigEndian.PutUint64(data[prefixLen:], uint64(nonce))
+				hash := sha256.Sum256(data)
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(pow.target) == -1 {
+					for {
+						current := atomic.LoadInt64(&best)
+						if int64(nonce) >= current || atomic.CompareAndSwapInt64(&best, current, int64(nonce)) {
+							break
+						}
+					}
+					break
+				}
+				if maxNonce-nonce <= threads {
+					break
+				}
+			}
+			atomic.AddUint64(&hashes, count%nonceCheckInterval)
+		}(i)
 	}
-	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	wg.Wait()
+	close(stopped)
+	pow.hashes = hashes
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	if best == int64(maxNonce) {
+		return 0, nil, errNonceExhausted
+	}
+
+	nonce := int(best)
+	hash := sha256.Sum256(pow.prepareData(nonce))
+	return nonce, hash[:], nil
+}
+
+// reportHashRate 每隔hashRateInterval打印一次算力，直到stopped被关闭
+func (pow *ProofOfWork) reportHashRate(hashes *uint64, stopped chan struct{}) {
+	ticker := time.NewTicker(hashRateInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+			log.Printf("Mining block at height %d: %.0f hashes/s\n",
+				pow.block.Height, float64(atomic.LoadUint64(hashes))/time.Since(start).Seconds())
+		}
+	}
 }
 
 // Validate 验证区块的 PoW
-func (pow *ProofOfWork) Validate() bool {
+// expectedBits 为区块所在高度应当使用的难度
+func (pow *ProofOfWork) Validate(expectedBits uint32) bool {
 	var hashInt big.Int
 
+	if pow.block.Bits != expectedBits {
+		return false
+	}
+	if pow.target.Sign() <= 0 || pow.target.Cmp(activeNet.PowLimit) > 0 {
+		return false
+	}
+
 	data := pow.prepareData(pow.block.Nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
@@ -78,3 +254,89 @@ func (pow *ProofOfWork) Validate() bool {
 
 	return isValid
 }
+
+// retarget 按照上一个调整周期实际用时与期望时长的比例调整target
+// 实际用时限制在期望时长的1/4到4倍之间
+func retarget(prevBits uint32, actualTimespan int64) uint32 {
+	targetTimespan := activeNet.TargetTimespan()
+	if actualTimespan < targetTimespan/4 {
+		actualTimespan = targetTimespan / 4
+	}
+	if actualTimespan > targetTimespan*4 {
+		actualTimespan = targetTimespan * 4
+	}
+
+	target := CompactToBig(prevBits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(targetTimespan))
+	if target.Cmp(activeNet.PowLimit) > 0 {
+		target.Set(activeNet.PowLimit)
+	}
+
+	return BigToCompact(target)
+}
+
+// blockWork 返回Bits对应的工作量，即找到满足target的hash平均需要计算的次数
+// work = 2^256 / (target + 1)
+func blockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// CompactToBig 将紧凑格式的Bits转换为target
+// 最高字节为指数，低三个字节为尾数，target = 尾数 * 256^(指数-3)
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+
+	return bn
+}
+
+// BigToCompact 将target转换为紧凑格式的Bits
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Abs(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Uint64())
+	}
+
+	// 尾数的符号位被占用时，尾数右移一个字节
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+)
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		want    string // hex
+	}{
+		{0x00000000, "0"},
+		{0x00123456, "0"},
+		{0x01003456, "0"},
+		{0x01123456, "12"},
+		{0x02123456, "1234"},
+		{0x03123456, "123456"},
+		{0x04123456, "12345600"},
+		{0x04923456, "-12345600"},
+		{0x05009234, "92340000"},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x20123456, "1234560000000000000000000000000000000000000000000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		if got := CompactToBig(tt.compact).Text(16); got != tt.want {
+			t.Errorf("CompactToBig(%#08x) = %s, want %s", tt.compact, got, tt.want)
+		}
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	tests := []struct {
+		n    string // hex
+		want uint32
+	}{
+		{"0", 0x00000000},
+		{"12", 0x01120000},
+		{"80", 0x02008000},
+		{"1234", 0x02123400},
+		{"123456", 0x03123456},
+		{"12345600", 0x04123456},
+		{"-12345600", 0x04923456},
+		{"92340000", 0x05009234},
+		{"ffff0000000000000000000000000000000000000000000000000000", 0x1d00ffff},
+		// 尾数只保留最高的三个字节
+		{"123456789a", 0x05123456},
+	}
+
+	for _, tt := range tests {
+		n, ok := new(big.Int).SetString(tt.n, 16)
+		if !ok {
+			t.Fatalf("invalid test number %s", tt.n)
+		}
+		if got := BigToCompact(n); got != tt.want {
+			t.Errorf("BigToCompact(%s) = %#08x, want %#08x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	for _, params := range networks {
+		if got := CompactToBig(params.PowLimitBits); got.Cmp(params.PowLimit) != 0 {
+			t.Errorf("%s: CompactToBig(PowLimitBits) = %x, want %x", params.Name, got, params.PowLimit)
+		}
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	timespan := activeNet.TargetTimespan()
+	// 比最低难度高8位的target
+	prevTarget := new(big.Int).Rsh(activeNet.PowLimit, 8)
+	prevBits := BigToCompact(prevTarget)
+	scaled := func(num, den int64) uint32 {
+		target := new(big.Int).Mul(prevTarget, big.NewInt(num))
+		return BigToCompact(target.Div(target, big.NewInt(den)))
+	}
+
+	tests := []struct {
+		name     string
+		prevBits uint32
+		actual   int64
+		want     uint32
+	}{
+		{"on schedule", prevBits, timespan, prevBits},
+		{"twice as slow", prevBits, timespan * 2, scaled(2, 1)},
+		{"twice as fast", prevBits, timespan / 2, scaled(1, 2)},
+		{"clamped to a quarter", prevBits, 1, scaled(1, 4)},
+		{"negative timespan clamped to a quarter", prevBits, -timespan, scaled(1, 4)},
+		{"clamped to four times", prevBits, timespan * 100, scaled(4, 1)},
+		{"limited to pow limit", activeNet.PowLimitBits, timespan * 4, activeNet.PowLimitBits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retarget(tt.prevBits, tt.actual); got != tt.want {
+				t.Errorf("retarget(%#08x, %d) = %#08x, want %#08x", tt.prevBits, tt.actual, got, tt.want)
+			}
+		})
+	}
+}
+
+// nonce范围很小时所有nonce都不满足难度，Seal需要增加extra nonce并重新计算coinbase交易ID与默克尔树根
+func TestSealExtraNonce(t *testing.T) {
+	defer func(nonces, threads int) {
+		maxNonce = nonces
+		miningThreads = threads
+	}(maxNonce, miningThreads)
+	maxNonce = 1
+	miningThreads = 2
+
+	// 区块内容固定，nonce 0 是否满足难度是确定的
+	coinbase := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{}, Vout: -1, ScriptSig: []byte("extra nonce test")}},
+		Vout:    []TXOutput{{Value: 10, ScriptPubKey: NewP2PKHScript(make([]byte, 20))}},
+	}
+	coinbase.ID = coinbase.CalcID()
+	block := NewBlock([]*Transaction{coinbase}, make([]byte, 32), 1, activeNet.PowLimitBits, 1700000000)
+	root := block.MerkleRoot
+	if NewProofOfWork(block).Validate(block.Bits) {
+		t.Fatal("nonce 0 of the test block already meets the target")
+	}
+
+	if err := (powEngine{}).Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(coinbase.Vin[0].ScriptSig, []byte("extra nonce test ")) {
+		t.Errorf("coinbase data = %q, want an extra nonce", coinbase.Vin[0].ScriptSig)
+	}
+	if !bytes.Equal(coinbase.ID, coinbase.CalcID()) {
+		t.Error("coinbase ID is not recomputed")
+	}
+	if bytes.Equal(block.MerkleRoot, root) || !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		t.Error("merkle root is not recomputed")
+	}
+	if block.Nonce != 0 || !NewProofOfWork(block).Validate(block.Bits) || !bytes.Equal(block.Hash, block.CalcHash()) {
+		t.Error("sealed block does not meet the target")
+	}
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	rpcVersion    = "2.0"
+	rpcCookieFile = "rpc.cookie" // 监听非回环地址时保存访问令牌的文件
+
+	// JSON-RPC 2.0 定义的错误码
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcUnauthorized   = -32001 // 服务端自定义的错误码: 没有提供访问令牌
+)
+
+// RPCServer JSON-RPC 2.0 服务
+// 请求通过HTTP POST发送，同时只处理一个请求
+// 监听非回环地址时，钱包方法需要在请求头中提供 Authorization: Bearer <rpc.cookie中的令牌>
+type RPCServer struct {
+	listen  string
+	token   string // 钱包方法的访问令牌，为空时不需要令牌
+	bc      *Blockchain
+	wallets *Wallets
+	mempool *Mempool
+	mu      sync.Mutex
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcMethod 一个RPC方法
+// Params 为参数名，按位置传递的参数按该顺序对应到参数名
+// Wallet 为true的方法使用钱包，监听非回环地址时需要访问令牌
+type rpcMethod struct {
+	Params  []string
+	Handler func(s *RPCServer, params rpcParams) (interface{}, error)
+	Wallet  bool
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"getbestblockhash": {nil, (*RPCServer).getBestBlockHash, false},
+	"getbalance":       {[]string{"address"}, (*RPCServer).getBalance, false},
+	"getblock":         {[]string{"hash"}, (*RPCServer).getBlock, false},
+	"getblockbyheight": {[]string{"height"}, (*RPCServer).getBlockByHeight, false},
+	"gettransaction":   {[]string{"txid"}, (*RPCServer).getTransaction, false},
+	"sendtoaddress":    {[]string{"from", "to", "amount", "fee", "locktime"}, (*RPCServer).sendToAddress, true},
+	"mine":             {[]string{"address", "maxtx"}, (*RPCServer).mine, false},
+	"createwallet":     {nil, (*RPCServer).createWallet, true},
+	"listaddresses":    {nil, (*RPCServer).listAddresses, true},
+	"walletpassphrase": {[]string{"passphrase", "timeout"}, (*RPCServer).walletPassphrase, true},
+	"walletlock":       {nil, (*RPCServer).walletLock, true},
+}
+
+// NewRPCServer 创建RPC服务，钱包文件不存在时使用空钱包
+func NewRPCServer(listen string, bc *Blockchain) *RPCServer {
+	wallets, err := NewWallets()
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+
+	return &RPCServer{
+		listen:  listen,
+		bc:      bc,
+		wallets: wallets,
+		mempool: NewMempool(&UTXOSet{bc}, bc.db),
+	}
+}
+
+// Start 监听地址并处理请求
+// 监听非回环地址时生成新的访问令牌并写入rpc.cookie
+func (s *RPCServer) Start() {
+	if !isLoopbackAddress(s.listen) {
+		token, err := writeRPCCookie()
+		if err != nil {
+			log.Panic(err)
+		}
+		s.token = token
+		log.Printf("Wallet methods require the token in %s\n", dataFilePath(rpcCookieFile))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleHTTP)
+
+	log.Printf("JSON-RPC server listening on %s\n", s.listen)
+	log.Panic(http.ListenAndServe(s.listen, mux))
+}
+
+// isLoopbackAddress 判断监听地址是否只接受本机的连接，没有指定主机时监听所有地址
+func isLoopbackAddress(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeRPCCookie 生成随机的访问令牌并以0600权限写入rpc.cookie
+func writeRPCCookie() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(data)
+
+	return token, ioutil.WriteFile(dataFilePath(rpcCookieFile), []byte(token), 0600)
+}
+
+// authorized 判断请求是否提供了访问令牌
+func (s *RPCServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+}
+
+// handleHTTP 处理单个请求或批量请求
+func (s *RPCServer) handleHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	authorized := s.authorized(r)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response interface{}
+	var batch []json.RawMessage
+	if json.Unmarshal(body, &batch) == nil {
+		if len(batch) == 0 {
+			response = newRPCErrorResponse(nil, rpcInvalidRequest, "Empty batch")
+		} else {
+			var responses []*rpcResponse
+			for _, raw := range batch {
+				if resp := s.handleRequest(raw, authorized); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+			if responses != nil {
+				response = responses
+			}
+		}
+	} else if resp := s.handleRequest(body, authorized); resp != nil {
+		response = resp
+	}
+
+	// 只包含通知的请求没有响应
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// handleRequest 处理一个请求，请求是通知(没有id)时返回nil
+// authorized 为HTTP请求是否提供了访问令牌
+func (s *RPCServer) handleRequest(raw []byte, authorized bool) *rpcResponse {
+	var req rpcRequest
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		return newRPCErrorResponse(nil, rpcParseError, err.Error())
+	}
+	if req.JSONRPC != rpcVersion || req.Method == "" {
+		return newRPCErrorResponse(req.ID, rpcInvalidRequest, "Invalid request")
+	}
+
+	result, err := s.call(req.Method, req.Params, authorized)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			return newRPCErrorResponse(req.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return newRPCErrorResponse(req.ID, rpcInternalError, err.Error())
+	}
+
+	// 结果为nil时也需要返回 "result": null
+	data, err := json.Marshal(result)
+	if err != nil {
+		return newRPCErrorResponse(req.ID, rpcInternalError, err.Error())
+	}
+
+	return &rpcResponse{JSONRPC: rpcVersion, Result: data, ID: req.ID}
+}
+
+// call 调用方法，方法中的panic作为错误返回
+func (s *RPCServer) call(name string, rawParams json.RawMessage, authorized bool) (result interface{}, err error) {
+	method, ok := rpcMethods[name]
+	if !ok {
+		return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("Method %q not found", name)}
+	}
+	if method.Wallet && !authorized {
+		return nil, &rpcError{rpcUnauthorized, fmt.Sprintf("Method %q requires the token in %s", name, rpcCookieFile)}
+	}
+
+	params, err := parseRPCParams(method.Params, rawParams)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	log.Printf("RPC %s\n", name)
+	return method.Handler(s, params)
+}
+
+func newRPCErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{code, message}, ID: id}
+}
+
+// rpcParams 参数名 -> 参数值
+type rpcParams map[string]json.RawMessage
+
+// parseRPCParams 解析按名称(对象)或按位置(数组)传递的参数
+func parseRPCParams(names []string, raw json.RawMessage) (rpcParams, error) {
+	params := make(rpcParams)
+	if len(raw) == 0 || string(raw) == "null" {
+		return params, nil
+	}
+
+	var positional []json.RawMessage
+	if json.Unmarshal(raw, &positional) == nil {
+		if len(positional) > len(names) {
+			return nil, &rpcError{rpcInvalidParams, "Too many params"}
+		}
+		for i, value := range positional {
+			params[names[i]] = value
+		}
+		return params, nil
+	}
+
+	err := json.Unmarshal(raw, &params)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, "Params must be an array or an object"}
+	}
+
+	return params, nil
+}
+
+// get 将参数name解析到v中，required为false时参数可以不存在
+func (p rpcParams) get(name string, v interface{}, required bool) error {
+	raw, ok := p[name]
+	if !ok {
+		if required {
+			return &rpcError{rpcInvalidParams, fmt.Sprintf("Missing param %q", name)}
+		}
+		return nil
+	}
+
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return &rpcError{rpcInvalidParams, fmt.Sprintf("Invalid param %q: %s", name, err)}
+	}
+
+	return nil
+}
+
+// getHex 解析hex编码的参数
+func (p rpcParams) getHex(name string) ([]byte, error) {
+	var s string
+	err := p.get(name, &s, true)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("Invalid param %q: %s", name, err)}
+	}
+
+	return data, nil
+}
+
+// getAddress 解析并校验地址参数
+func (p rpcParams) getAddress(name string) (string, error) {
+	var address string
+	err := p.get(name, &address, true)
+	if err != nil {
+		return "", err
+	}
+	if !ValidateAddress(address) {
+		return "", &rpcError{rpcInvalidParams, fmt.Sprintf("Invalid address %q", address)}
+	}
+
+	return address, nil
+}
+
+func (s *RPCServer) getBestBlockHash(params rpcParams) (interface{}, error) {
+	return hex.EncodeToString(s.bc.tip), nil
+}
+
+func (s *RPCServer) getBalance(params rpcParams) (interface{}, error) {
+	address, err := params.getAddress("address")
+	if err != nil {
+		return nil, err
+	}
+
+	UTXOSet := UTXOSet{s.bc}
+	balance := 0
+	for _, out := range UTXOSet.FindUTXO(addressToScript(address)) {
+		balance += out.Value
+	}
+
+	return balance, nil
+}
+
+func (s *RPCServer) getBlock(params rpcParams) (interface{}, error) {
+	hash, err := params.getHex("hash")
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockJSON(&block), nil
+}
+
+func (s *RPCServer) getBlockByHeight(params rpcParams) (interface{}, error) {
+	var height int
+	err := params.get("height", &height, true)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockJSON(&block), nil
+}
+
+// getTransaction 先在交易池中查找，再查找链上的交易
+func (s *RPCServer) getTransaction(params rpcParams) (interface{}, error) {
+	txid, err := params.getHex("txid")
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, ok := s.mempool.Get(txid); ok {
+		return newTransactionJSON(&tx), nil
+	}
+
+	tx, err := s.bc.FindTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTransactionJSON(&tx), nil
+}
+
+// sendToAddress 创建交易并加入交易池，返回交易ID
+// 钱包加密时需要先调用walletpassphrase解锁
+func (s *RPCServer) sendToAddress(params rpcParams) (interface{}, error) {
+	from, err := params.getAddress("from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := params.getAddress("to")
+	if err != nil {
+		return nil, err
+	}
+
+	var amount, fee int
+	var lockTime uint32
+	err = params.get("amount", &amount, true)
+	if err != nil {
+		return nil, err
+	}
+	err = params.get("fee", &fee, false)
+	if err != nil {
+		return nil, err
+	}
+	err = params.get("locktime", &lockTime, false)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 || fee < 0 {
+		return nil, &rpcError{rpcInvalidParams, "Amount must be positive and fee must not be negative"}
+	}
+
+	if _, ok := s.wallets.Wallets[from]; !ok {
+		return nil, fmt.Errorf("Address %s is not in the wallet", from)
+	}
+	if s.wallets.IsLocked() {
+		return nil, errors.New("Wallet is locked, unlock it with walletpassphrase first")
+	}
+	wallet := s.wallets.GetWallet(from)
+
+	UTXOSet := UTXOSet{s.bc}
+	tx := NewUTXOTransaction(&wallet, to, amount, fee, lockTime, 0, &UTXOSet, s.mempool)
+	err = s.mempool.Add(*tx, &UTXOSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// mine 将交易池中的交易打包成区块，返回区块hash
+func (s *RPCServer) mine(params rpcParams) (interface{}, error) {
+	address, err := params.getAddress("address")
+	if err != nil {
+		return nil, err
+	}
+	var maxTx int
+	err = params.get("maxtx", &maxTx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.bc.MineBlock(context.Background(), address, s.mempool.Transactions(maxTx, &UTXOSet{s.bc}))
+	if err != nil {
+		return nil, err
+	}
+	s.mempool.RemoveBlockTransactions(block)
+
+	return hex.EncodeToString(block.Hash), nil
+}
+
+func (s *RPCServer) createWallet(params rpcParams) (interface{}, error) {
+	address, err := s.wallets.CreateWallet()
+	if err != nil {
+		return nil, err
+	}
+	s.wallets.SaveToFile()
+
+	return address, nil
+}
+
+func (s *RPCServer) listAddresses(params rpcParams) (interface{}, error) {
+	addresses := s.wallets.GetAddresses()
+	if addresses == nil {
+		addresses = []string{}
+	}
+
+	return addresses, nil
+}
+
+// walletPassphrase 解锁钱包，timeout秒后自动锁定，为0时不自动锁定
+func (s *RPCServer) walletPassphrase(params rpcParams) (interface{}, error) {
+	var passphrase string
+	var timeout int
+	err := params.get("passphrase", &passphrase, true)
+	if err != nil {
+		return nil, err
+	}
+	err = params.get("timeout", &timeout, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.wallets.Unlock(passphrase, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (s *RPCServer) walletLock(params rpcParams) (interface{}, error) {
+	s.wallets.Lock()
+
+	return nil, nil
+}
+
+// blockJSON 区块的JSON表示，hash等字节数组使用hex编码
+type blockJSON struct {
+	Hash          string            `json:"hash"`
+	Version       int               `json:"version"`
+	PrevBlockHash string            `json:"previousblockhash"`
+	MerkleRoot    string            `json:"merkleroot"`
+	Timestamp     int64             `json:"time"`
+	Bits          string            `json:"bits"`
+	Nonce         int               `json:"nonce"`
+	Height        int               `json:"height"`
+	Transactions  []transactionJSON `json:"tx"`
+}
+
+// transactionJSON 交易的JSON表示
+type transactionJSON struct {
+	ID       string         `json:"txid"`
+	Vin      []txInputJSON  `json:"vin"`
+	Vout     []txOutputJSON `json:"vout"`
+	LockTime uint32         `json:"locktime"`
+}
+
+// txInputJSON 交易输入的JSON表示，coinbase交易的输入只有Coinbase字段
+type txInputJSON struct {
+	Coinbase  string      `json:"coinbase,omitempty"`
+	Txid      string      `json:"txid,omitempty"`
+	Vout      int         `json:"vout"`
+	ScriptSig *scriptJSON `json:"scriptsig,omitempty"`
+	Sequence  uint32      `json:"sequence"`
+}
+
+// txOutputJSON 交易输出的JSON表示，非标准脚本的Address为空
+type txOutputJSON struct {
+	Value        int        `json:"value"`
+	ScriptPubKey scriptJSON `json:"scriptpubkey"`
+	Address      string     `json:"address,omitempty"`
+}
+
+// scriptJSON 脚本的可读形式与hex编码
+type scriptJSON struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+func newScriptJSON(script []byte) scriptJSON {
+	return scriptJSON{DisasmScript(script), hex.EncodeToString(script)}
+}
+
+func newTXOutputJSON(out *TXOutput) txOutputJSON {
+	return txOutputJSON{
+		Value:        out.Value,
+		ScriptPubKey: newScriptJSON(out.ScriptPubKey),
+		Address:      out.Address(),
+	}
+}
+
+func newBlockJSON(block *Block) blockJSON {
+	result := blockJSON{
+		Hash:          hex.EncodeToString(block.Hash),
+		Version:       block.Version,
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Timestamp:     block.Timestamp,
+		Bits:          fmt.Sprintf("%08x", block.Bits),
+		Nonce:         block.Nonce,
+		Height:        block.Height,
+	}
+	for _, tx := range block.Transactions {
+		result.Transactions = append(result.Transactions, newTransactionJSON(tx))
+	}
+
+	return result
+}
+
+func newTransactionJSON(tx *Transaction) transactionJSON {
+	result := transactionJSON{ID: hex.EncodeToString(tx.ID), LockTime: tx.LockTime}
+
+	for _, vin := range tx.Vin {
+		if tx.IsCoinbase() {
+			result.Vin = append(result.Vin, txInputJSON{Coinbase: hex.EncodeToString(vin.ScriptSig), Vout: vin.Vout, Sequence: vin.Sequence})
+			continue
+		}
+		scriptSig := newScriptJSON(vin.ScriptSig)
+		result.Vin = append(result.Vin, txInputJSON{
+			Txid:      hex.EncodeToString(vin.Txid),
+			Vout:      vin.Vout,
+			ScriptSig: &scriptSig,
+			Sequence:  vin.Sequence,
+		})
+	}
+	for i := range tx.Vout {
+		result.Vout = append(result.Vout, newTXOutputJSON(&tx.Vout[i]))
+	}
+
+	return result
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestIsLoopbackAddress(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   bool
+	}{
+		{"127.0.0.1:8545", true},
+		{"localhost:8545", true},
+		{"[::1]:8545", true},
+		{":8545", false},
+		{"0.0.0.0:8545", false},
+		{"192.168.1.2:8545", false},
+		{"8545", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLoopbackAddress(tt.listen); got != tt.want {
+			t.Errorf("isLoopbackAddress(%q) = %v, want %v", tt.listen, got, tt.want)
+		}
+	}
+}
+
+// 设置了访问令牌时，没有令牌的请求不能调用钱包方法
+func TestRPCWalletMethodsRequireToken(t *testing.T) {
+	s := &RPCServer{token: "secret"}
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"Bearer wrong", false},
+		{"secret", false},
+		{"Bearer secret", true},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodPost, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if got := s.authorized(r); got != tt.want {
+			t.Errorf("authorized with %q = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+
+	for name, method := range rpcMethods {
+		if !method.Wallet {
+			continue
+		}
+		_, err := s.call(name, nil, false)
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != rpcUnauthorized {
+			t.Errorf("%s without token: error = %v, want code %d", name, err, rpcUnauthorized)
+		}
+	}
+
+	if !(&RPCServer{}).authorized(&http.Request{Header: http.Header{}}) {
+		t.Error("request without token is rejected when no token is set")
+	}
+}
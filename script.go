@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// 操作码，取值与比特币脚本相同
+// 0x01-0x4b 表示将之后对应长度的数据压入栈
+const (
+	OP_0                   = 0x00
+	OP_PUSHDATA1           = 0x4c
+	OP_PUSHDATA2           = 0x4d
+	OP_1NEGATE             = 0x4f
+	OP_1                   = 0x51
+	OP_16                  = 0x60
+	OP_NOP                 = 0x61
+	OP_VERIFY              = 0x69
+	OP_RETURN              = 0x6a
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_EQUAL               = 0x87
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+)
+
+const (
+	maxScriptSize    = 10000 // 脚本的最大字节数
+	maxScriptElement = 520   // 压入栈的数据的最大字节数
+	maxStackSize     = 1000
+	maxMultisigKeys  = 16
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+}
+
+// scriptOp 解析后的一条指令，压入数据的指令Data为对应的数据
+type scriptOp struct {
+	Opcode byte
+	Data   []byte
+}
+
+// parseScript 将脚本解析为指令序列
+func parseScript(script []byte) ([]scriptOp, error) {
+	var ops []scriptOp
+
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		var dataLen int
+		switch {
+		case opcode > OP_0 && opcode < OP_PUSHDATA1:
+			dataLen = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("Script is truncated")
+			}
+			dataLen = int(script[i])
+			i++
+		case opcode == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("Script is truncated")
+			}
+			dataLen = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, scriptOp{Opcode: opcode})
+			continue
+		}
+
+		if i+dataLen > len(script) {
+			return nil, errors.New("Script is truncated")
+		}
+		ops = append(ops, scriptOp{Opcode: opcode, Data: script[i : i+dataLen]})
+		i += dataLen
+	}
+
+	return ops, nil
+}
+
+// isPushOnly 脚本是否只包含压入数据的指令
+func isPushOnly(ops []scriptOp) bool {
+	for _, op := range ops {
+		if op.Opcode > OP_16 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ScriptBuilder 按顺序拼接指令与数据生成脚本
+type ScriptBuilder struct {
+	script []byte
+}
+
+// AddOp 添加一个操作码
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.script = append(b.script, opcode)
+	return b
+}
+
+// AddData 添加压入data的指令，根据数据长度选择最短的编码
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	switch {
+	case len(data) == 0:
+		b.script = append(b.script, OP_0)
+	case len(data) < OP_PUSHDATA1:
+		b.script = append(b.script, byte(len(data)))
+	case len(data) <= 0xff:
+		b.script = append(b.script, OP_PUSHDATA1, byte(len(data)))
+	default:
+		b.script = append(b.script, OP_PUSHDATA2, byte(len(data)), byte(len(data)>>8))
+	}
+	b.script = append(b.script, data...)
+
+	return b
+}
+
+// Script 返回生成的脚本
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// NewP2PKHScript 创建支付到公钥hash的锁定脚本
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func NewP2PKHScript(pubKeyHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG)
+
+	return b.Script()
+}
+
+// NewP2PKHScriptSig 创建解锁P2PKH输出的脚本 <signature> <pubKey>
+func NewP2PKHScriptSig(signature, pubKey []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddData(signature).AddData(pubKey)
+
+	return b.Script()
+}
+
+// NewP2SHScript 创建支付到脚本hash的锁定脚本
+// OP_HASH160 <scriptHash> OP_EQUAL
+func NewP2SHScript(scriptHash []byte) []byte {
+	b := &ScriptBuilder{}
+	b.AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL)
+
+	return b.Script()
+}
+
+// NewMultisigScript 创建M-of-N多重签名脚本，作为P2SH的赎回脚本
+// OP_M <pubKey1> ... <pubKeyN> OP_N OP_CHECKMULTISIG
+func NewMultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultisigKeys {
+		return nil, fmt.Errorf("Number of public keys must be between 1 and %d", maxMultisigKeys)
+	}
+	if m < 1 || m > len(pubKeys) {
+		return nil, errors.New("Number of required signatures must be between 1 and the number of public keys")
+	}
+
+	b := &ScriptBuilder{}
+	b.AddOp(byte(OP_1 + m - 1))
+	for _, pubKey := range pubKeys {
+		b.AddData(pubKey)
+	}
+	b.AddOp(byte(OP_1 + len(pubKeys) - 1)).AddOp(OP_CHECKMULTISIG)
+
+	script := b.Script()
+	if len(script) > maxScriptElement {
+		return nil, errors.New("Multisig script is too large")
+	}
+
+	return script, nil
+}
+
+// ParseMultisigScript 解析多重签名脚本，返回需要的签名数与公钥
+func ParseMultisigScript(script []byte) (int, [][]byte, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	n := len(ops) - 3
+	if n < 1 || ops[len(ops)-1].Opcode != OP_CHECKMULTISIG ||
+		ops[0].Opcode < OP_1 || ops[0].Opcode > OP_16 || int(ops[len(ops)-2].Opcode) != OP_1+n-1 {
+		return 0, nil, errors.New("Script is not a multisig script")
+	}
+	m := int(ops[0].Opcode-OP_1) + 1
+	if m > n {
+		return 0, nil, errors.New("Script is not a multisig script")
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.Data == nil {
+			return 0, nil, errors.New("Script is not a multisig script")
+		}
+		pubKeys = append(pubKeys, op.Data)
+	}
+
+	return m, pubKeys, nil
+}
+
+// IsUnspendable 判断锁定脚本是否以OP_RETURN开头，这样的输出无法被任何解锁脚本使用
+func IsUnspendable(scriptPubKey []byte) bool {
+	return len(scriptPubKey) > 0 && scriptPubKey[0] == OP_RETURN
+}
+
+// ExtractScriptHash 如果锁定脚本是P2SH脚本，返回其中的脚本hash
+func ExtractScriptHash(scriptPubKey []byte) ([]byte, bool) {
+	ops, err := parseScript(scriptPubKey)
+	if err != nil || len(ops) != 3 {
+		return nil, false
+	}
+
+	if ops[0].Opcode != OP_HASH160 || len(ops[1].Data) != 20 || ops[2].Opcode != OP_EQUAL {
+		return nil, false
+	}
+
+	return ops[1].Data, true
+}
+
+// ExtractPubKeyHash 如果锁定脚本是P2PKH脚本，返回其中的公钥hash
+func ExtractPubKeyHash(scriptPubKey []byte) ([]byte, bool) {
+	ops, err := parseScript(scriptPubKey)
+	if err != nil || len(ops) != 5 {
+		return nil, false
+	}
+
+	if ops[0].Opcode != OP_DUP || ops[1].Opcode != OP_HASH160 || len(ops[2].Data) != 20 ||
+		ops[3].Opcode != OP_EQUALVERIFY || ops[4].Opcode != OP_CHECKSIG {
+		return nil, false
+	}
+
+	return ops[2].Data, true
+}
+
+// DisasmScript 返回脚本的可读形式，数据以hex表示
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return "[error]"
+	}
+
+	var parts []string
+	for _, op := range ops {
+		switch {
+		case op.Data != nil:
+			parts = append(parts, hex.EncodeToString(op.Data))
+		case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.Opcode-OP_1+1))
+		case opcodeNames[op.Opcode] != "":
+			parts = append(parts, opcodeNames[op.Opcode])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN_%02x", op.Opcode))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// VerifyScript 依次执行解锁脚本与锁定脚本，栈顶为真时验证通过
+// 锁定脚本为P2SH脚本时，解锁脚本最后压入的数据为赎回脚本，使用其余数据再执行赎回脚本
+// 解锁脚本只能包含压入数据的指令；sigHash 为签名验证的数据
+func VerifyScript(scriptSig, scriptPubKey, sigHash []byte) error {
+	if len(scriptSig) > maxScriptSize || len(scriptPubKey) > maxScriptSize {
+		return errors.New("Script is too large")
+	}
+
+	sigOps, err := parseScript(scriptSig)
+	if err != nil {
+		return err
+	}
+	if !isPushOnly(sigOps) {
+		return errors.New("ScriptSig is not push only")
+	}
+	pubKeyOps, err := parseScript(scriptPubKey)
+	if err != nil {
+		return err
+	}
+
+	vm := &scriptVM{sigHash: sigHash}
+	err = vm.execute(sigOps)
+	if err != nil {
+		return err
+	}
+	p2shStack := append([][]byte{}, vm.stack...)
+
+	err = vm.execute(pubKeyOps)
+	if err != nil {
+		return err
+	}
+	if len(vm.stack) == 0 || !castToBool(vm.stack[len(vm.stack)-1]) {
+		return errors.New("Script evaluated to false")
+	}
+
+	if _, ok := ExtractScriptHash(scriptPubKey); !ok {
+		return nil
+	}
+
+	vm.stack = p2shStack
+	redeemScript, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	redeemOps, err := parseScript(redeemScript)
+	if err != nil {
+		return err
+	}
+	err = vm.execute(redeemOps)
+	if err != nil {
+		return err
+	}
+	if len(vm.stack) == 0 || !castToBool(vm.stack[len(vm.stack)-1]) {
+		return errors.New("Redeem script evaluated to false")
+	}
+
+	return nil
+}
+
+// scriptVM 脚本解释器，解锁脚本与锁定脚本共用一个栈
+type scriptVM struct {
+	stack   [][]byte
+	sigHash []byte
+}
+
+func (vm *scriptVM) push(data []byte) error {
+	if len(data) > maxScriptElement {
+		return errors.New("Push data is too large")
+	}
+	if len(vm.stack) >= maxStackSize {
+		return errors.New("Stack overflow")
+	}
+	vm.stack = append(vm.stack, data)
+
+	return nil
+}
+
+func (vm *scriptVM) pop() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("Stack underflow")
+	}
+	data := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return data, nil
+}
+
+// execute 执行指令序列
+func (vm *scriptVM) execute(ops []scriptOp) error {
+	for _, op := range ops {
+		err := vm.step(op)
+		if err != nil {
+			return fmt.Errorf("%s: %s", opName(op), err)
+		}
+	}
+
+	return nil
+}
+
+// step 执行一条指令
+func (vm *scriptVM) step(op scriptOp) error {
+	switch {
+	case op.Data != nil || op.Opcode == OP_0:
+		return vm.push(op.Data)
+	case op.Opcode == OP_1NEGATE:
+		return vm.push([]byte{0x81})
+	case op.Opcode >= OP_1 && op.Opcode <= OP_16:
+		return vm.push([]byte{op.Opcode - OP_1 + 1})
+	}
+
+	switch op.Opcode {
+	case OP_NOP:
+		return nil
+
+	case OP_VERIFY:
+		return vm.verify()
+
+	case OP_RETURN:
+		return errors.New("Script returned early")
+
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+
+	case OP_DUP:
+		if len(vm.stack) == 0 {
+			return errors.New("Stack underflow")
+		}
+		return vm.push(vm.stack[len(vm.stack)-1])
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		err = vm.push(fromBool(bytes.Equal(a, b)))
+		if err != nil || op.Opcode == OP_EQUAL {
+			return err
+		}
+		return vm.verify()
+
+	case OP_SHA256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return vm.push(hash[:])
+
+	case OP_HASH160:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(HashPubKey(data))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		err = vm.push(fromBool(checkSignature(signature, pubKey, vm.sigHash)))
+		if err != nil || op.Opcode == OP_CHECKSIG {
+			return err
+		}
+		return vm.verify()
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		ok, err := vm.checkMultisig()
+		if err != nil {
+			return err
+		}
+		err = vm.push(fromBool(ok))
+		if err != nil || op.Opcode == OP_CHECKMULTISIG {
+			return err
+		}
+		return vm.verify()
+	}
+
+	return errors.New("Unknown opcode")
+}
+
+// checkMultisig 栈中依次为 <sig1> ... <sigM> M <pubKey1> ... <pubKeyN> N
+// 签名需要按公钥的顺序排列，每个公钥最多对应一个签名
+func (vm *scriptVM) checkMultisig() (bool, error) {
+	n, err := vm.popSmallInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 1 || n > maxMultisigKeys {
+		return false, errors.New("Invalid number of public keys")
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	m, err := vm.popSmallInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 1 || m > n {
+		return false, errors.New("Invalid number of signatures")
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		signatures[i], err = vm.pop()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	k := 0
+	for _, signature := range signatures {
+		for k < len(pubKeys) && !checkSignature(signature, pubKeys[k], vm.sigHash) {
+			k++
+		}
+		if k == len(pubKeys) {
+			return false, nil
+		}
+		k++
+	}
+
+	return true, nil
+}
+
+// popSmallInt 弹出一个由OP_0-OP_16压入的数
+func (vm *scriptVM) popSmallInt() (int, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 1 || (len(data) == 1 && data[0] > 16) {
+		return 0, errors.New("Number is out of range")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	return int(data[0]), nil
+}
+
+// verify 弹出栈顶，栈顶为假时失败
+func (vm *scriptVM) verify() error {
+	data, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !castToBool(data) {
+		return errors.New("Verify failed")
+	}
+
+	return nil
+}
+
+// checkSignature 验证签名，签名为补齐后的 r||s，公钥为补齐后的 X||Y
+func checkSignature(signature, pubKey, sigHash []byte) bool {
+	if len(signature) == 0 || len(signature)%2 != 0 || len(pubKey) == 0 || len(pubKey)%2 != 0 {
+		return false
+	}
+
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if !curve.IsOnCurve(x, y) {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:len(signature)/2])
+	s := new(big.Int).SetBytes(signature[len(signature)/2:])
+
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, sigHash, r, s)
+}
+
+// castToBool 数据不全为0(或负0)时为真
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			// 负0
+			return !(i == len(data)-1 && b == 0x80)
+		}
+	}
+
+	return false
+}
+
+func fromBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{}
+}
+
+func opName(op scriptOp) string {
+	if op.Data != nil {
+		return "OP_PUSH"
+	}
+	if name, ok := opcodeNames[op.Opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_%02x", op.Opcode)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestVerifyScript(t *testing.T) {
+	sigHash := sha256.Sum256([]byte("transaction"))
+	otherHash := sha256.Sum256([]byte("other transaction"))
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+
+	aliceSig := signHash(alice.PrivateKey, sigHash[:])
+	bobSig := signHash(bob.PrivateKey, sigHash[:])
+	carolSig := signHash(carol.PrivateKey, sigHash[:])
+	badSig := append([]byte{}, aliceSig...)
+	badSig[len(badSig)-1] ^= 1
+
+	p2pkh := NewP2PKHScript(HashPubKey(alice.PublicKey))
+
+	// 2-of-3 多重签名
+	redeemScript, err := NewMultisigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2sh := NewP2SHScript(HashPubKey(redeemScript))
+	multisigSig := func(signatures ...[]byte) []byte {
+		b := &ScriptBuilder{}
+		for _, signature := range signatures {
+			b.AddData(signature)
+		}
+		return b.AddData(redeemScript).Script()
+	}
+
+	// 赎回脚本为 OP_1 的P2SH输出，任何人都可以使用
+	trueScript := []byte{OP_1}
+	p2shTrue := NewP2SHScript(HashPubKey(trueScript))
+
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		sigHash      []byte
+		wantErr      bool
+	}{
+		{"p2pkh", NewP2PKHScriptSig(aliceSig, alice.PublicKey), p2pkh, sigHash[:], false},
+		{"p2pkh wrong key", NewP2PKHScriptSig(bobSig, bob.PublicKey), p2pkh, sigHash[:], true},
+		{"p2pkh bad signature", NewP2PKHScriptSig(badSig, alice.PublicKey), p2pkh, sigHash[:], true},
+		{"p2pkh signature of other data", NewP2PKHScriptSig(aliceSig, alice.PublicKey), p2pkh, otherHash[:], true},
+		{"p2pkh empty signature", NewP2PKHScriptSig(nil, alice.PublicKey), p2pkh, sigHash[:], true},
+		{"p2pkh missing public key", (&ScriptBuilder{}).AddData(aliceSig).Script(), p2pkh, sigHash[:], true},
+		{"scriptSig not push only", append(NewP2PKHScriptSig(aliceSig, alice.PublicKey), OP_DROP), p2pkh, sigHash[:], true},
+		{"p2sh", (&ScriptBuilder{}).AddData(trueScript).Script(), p2shTrue, sigHash[:], false},
+		{"p2sh wrong redeem script", (&ScriptBuilder{}).AddData([]byte{OP_1, OP_1}).Script(), p2shTrue, sigHash[:], true},
+		{"p2sh redeem script evaluates to false", (&ScriptBuilder{}).AddData([]byte{OP_0}).Script(),
+			NewP2SHScript(HashPubKey([]byte{OP_0})), sigHash[:], true},
+		{"2-of-3 first and second", multisigSig(aliceSig, bobSig), p2sh, sigHash[:], false},
+		{"2-of-3 first and third", multisigSig(aliceSig, carolSig), p2sh, sigHash[:], false},
+		{"2-of-3 second and third", multisigSig(bobSig, carolSig), p2sh, sigHash[:], false},
+		{"2-of-3 out of order", multisigSig(carolSig, aliceSig), p2sh, sigHash[:], true},
+		{"2-of-3 same signature twice", multisigSig(aliceSig, aliceSig), p2sh, sigHash[:], true},
+		{"2-of-3 one signature", multisigSig(aliceSig), p2sh, sigHash[:], true},
+		{"2-of-3 bad signature", multisigSig(badSig, bobSig), p2sh, sigHash[:], true},
+		{"2-of-3 signatures of other data", multisigSig(aliceSig, bobSig), p2sh, otherHash[:], true},
+		{"op_return", nil, []byte{OP_RETURN}, sigHash[:], true},
+		{"empty scripts", nil, nil, sigHash[:], true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyScript(tt.scriptSig, tt.scriptPubKey, tt.sigHash)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  []byte
+		wantOps int
+		wantErr bool
+	}{
+		{"empty", nil, 0, false},
+		{"p2pkh", NewP2PKHScript(make([]byte, 20)), 5, false},
+		{"pushdata1", append([]byte{OP_PUSHDATA1, 3}, 1, 2, 3), 1, false},
+		{"pushdata2", append([]byte{OP_PUSHDATA2, 2, 0}, 1, 2), 1, false},
+		{"truncated push", []byte{5, 1, 2}, 0, true},
+		{"truncated pushdata1", []byte{OP_PUSHDATA1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := parseScript(tt.script)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(ops) != tt.wantOps {
+				t.Errorf("parseScript() returned %d ops, want %d", len(ops), tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestIsUnspendable(t *testing.T) {
+	tests := []struct {
+		script []byte
+		want   bool
+	}{
+		{[]byte{OP_RETURN}, true},
+		{[]byte{OP_RETURN, 0x01, 0x00}, true},
+		{NewP2PKHScript(make([]byte, 20)), false},
+		{[]byte{OP_1, OP_RETURN}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsUnspendable(tt.script); got != tt.want {
+			t.Errorf("IsUnspendable(%x) = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 交易与区块的规范二进制格式，用于计算hash、存储与网络传输，与Go的类型无关
+//
+// 基本类型:
+//   uvarint  无符号整数，LEB128编码(每字节低7位为数据，最高位为1表示后面还有字节)，必须使用最短的编码
+//   varint   有符号整数，先做zigzag编码((n << 1) ^ (n >> 63))再按uvarint编码
+//   bytes    uvarint长度 + 内容
+//
+// TXInput:     bytes Txid | varint Vout | bytes ScriptSig | uvarint Sequence
+// TXOutput:    varint Value | bytes ScriptPubKey
+// Transaction: uvarint Version | uvarint 输入数 | TXInput... | uvarint 输出数 | TXOutput... | uvarint LockTime
+// Block:       varint Version | bytes PrevBlockHash | bytes MerkleRoot | varint Timestamp | uvarint Bits |
+//              varint Nonce | varint Height | uvarint 交易数 | bytes Transaction... | bytes SignerPubKey | bytes Signature
+//
+// 交易ID与区块hash由内容计算，不包含在序列化结果中
+
+var errTrailingData = errors.New("Unexpected data after the end")
+
+// canonicalWriter 按规范格式写入数据
+type canonicalWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *canonicalWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *canonicalWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (w *canonicalWriter) bytes(data []byte) {
+	w.uvarint(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+// canonicalReader 按规范格式读取数据，出现错误之后的读取都返回零值，错误保存在err中
+type canonicalReader struct {
+	data []byte
+	err  error
+}
+
+func (r *canonicalReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("Invalid varint")
+		return 0
+	}
+	var b [binary.MaxVarintLen64]byte
+	if binary.PutUvarint(b[:], v) != n {
+		r.err = errors.New("Varint is not minimally encoded")
+		return 0
+	}
+	r.data = r.data[n:]
+
+	return v
+}
+
+func (r *canonicalReader) varint() int64 {
+	u := r.uvarint()
+	// zigzag解码
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *canonicalReader) uint32() uint32 {
+	v := r.uvarint()
+	if v > 0xffffffff && r.err == nil {
+		r.err = fmt.Errorf("Value %d overflows uint32", v)
+	}
+
+	return uint32(v)
+}
+
+func (r *canonicalReader) bytes() []byte {
+	n := r.count()
+	if r.err != nil {
+		return nil
+	}
+
+	var data []byte
+	if n > 0 {
+		data = append(data, r.data[:n]...)
+		r.data = r.data[n:]
+	}
+
+	return data
+}
+
+// count 读取元素个数或字节数，每个元素至少占一个字节，不能超过剩余的数据长度
+func (r *canonicalReader) count() int {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = errors.New("Length exceeds the remaining data")
+		return 0
+	}
+
+	return int(n)
+}
+
+// end 检查数据已经全部读取，返回读取过程中的错误
+func (r *canonicalReader) end() error {
+	if r.err == nil && len(r.data) != 0 {
+		r.err = errTrailingData
+	}
+
+	return r.err
+}
+
+func (in *TXInput) encode(w *canonicalWriter) {
+	w.bytes(in.Txid)
+	w.varint(int64(in.Vout))
+	w.bytes(in.ScriptSig)
+	w.uvarint(uint64(in.Sequence))
+}
+
+func (in *TXInput) decode(r *canonicalReader) {
+	in.Txid = r.bytes()
+	in.Vout = int(r.varint())
+	in.ScriptSig = r.bytes()
+	in.Sequence = r.uint32()
+}
+
+func (out *TXOutput) encode(w *canonicalWriter) {
+	w.varint(int64(out.Value))
+	w.bytes(out.ScriptPubKey)
+}
+
+func (out *TXOutput) decode(r *canonicalReader) {
+	out.Value = int(r.varint())
+	out.ScriptPubKey = r.bytes()
+}
+
+func (tx *Transaction) encode(w *canonicalWriter) {
+	w.uvarint(uint64(tx.Version))
+	w.uvarint(uint64(len(tx.Vin)))
+	for i := range tx.Vin {
+		tx.Vin[i].encode(w)
+	}
+	w.uvarint(uint64(len(tx.Vout)))
+	for i := range tx.Vout {
+		tx.Vout[i].encode(w)
+	}
+	w.uvarint(uint64(tx.LockTime))
+}
+
+func (tx *Transaction) decode(r *canonicalReader) {
+	tx.Version = r.uint32()
+	if n := r.count(); n > 0 {
+		tx.Vin = make([]TXInput, n)
+		for i := range tx.Vin {
+			tx.Vin[i].decode(r)
+		}
+	}
+	if n := r.count(); n > 0 {
+		tx.Vout = make([]TXOutput, n)
+		for i := range tx.Vout {
+			tx.Vout[i].decode(r)
+		}
+	}
+	tx.LockTime = r.uint32()
+}
+
+// decodeTransaction 解析规范格式的交易并计算交易ID
+func decodeTransaction(data []byte) (Transaction, error) {
+	var tx Transaction
+
+	r := &canonicalReader{data: data}
+	tx.decode(r)
+	if err := r.end(); err != nil {
+		return Transaction{}, err
+	}
+	tx.ID = tx.CalcID()
+
+	return tx, nil
+}
+
+func (b *Block) encode(w *canonicalWriter) {
+	w.varint(int64(b.Version))
+	w.bytes(b.PrevBlockHash)
+	w.bytes(b.MerkleRoot)
+	w.varint(b.Timestamp)
+	w.uvarint(uint64(b.Bits))
+	w.varint(int64(b.Nonce))
+	w.varint(int64(b.Height))
+	w.uvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		w.bytes(tx.Serialize())
+	}
+	w.bytes(b.SignerPubKey)
+	w.bytes(b.Signature)
+}
+
+// decodeBlock 解析规范格式的区块并计算区块hash与交易ID
+func decodeBlock(data []byte) (*Block, error) {
+	var block Block
+
+	r := &canonicalReader{data: data}
+	block.Version = int(r.varint())
+	block.PrevBlockHash = r.bytes()
+	block.MerkleRoot = r.bytes()
+	block.Timestamp = r.varint()
+	block.Bits = r.uint32()
+	block.Nonce = int(r.varint())
+	block.Height = int(r.varint())
+	if n := r.count(); n > 0 {
+		block.Transactions = make([]*Transaction, n)
+		for i := range block.Transactions {
+			txData := r.bytes()
+			if r.err != nil {
+				break
+			}
+			tx, err := decodeTransaction(txData)
+			if err != nil {
+				return nil, fmt.Errorf("Transaction %d: %s", i, err)
+			}
+			block.Transactions[i] = &tx
+		}
+	}
+	block.SignerPubKey = r.bytes()
+	block.Signature = r.bytes()
+	if err := r.end(); err != nil {
+		return nil, err
+	}
+	block.Hash = block.CalcHash()
+
+	return &block, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// sameTransaction 比较两个交易，空的字节串与nil相同
+func sameTransaction(a, b *Transaction) bool {
+	if a.Version != b.Version || !bytes.Equal(a.ID, b.ID) || a.LockTime != b.LockTime ||
+		len(a.Vin) != len(b.Vin) || len(a.Vout) != len(b.Vout) {
+		return false
+	}
+	for i := range a.Vin {
+		x, y := a.Vin[i], b.Vin[i]
+		if !bytes.Equal(x.Txid, y.Txid) || x.Vout != y.Vout || !bytes.Equal(x.ScriptSig, y.ScriptSig) || x.Sequence != y.Sequence {
+			return false
+		}
+	}
+	for i := range a.Vout {
+		if a.Vout[i].Value != b.Vout[i].Value || !bytes.Equal(a.Vout[i].ScriptPubKey, b.Vout[i].ScriptPubKey) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestTransactionSerializeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   Transaction
+	}{
+		{"empty", Transaction{Version: txVersion}},
+		{"coinbase", *NewCoinbaseTX(string(NewWallet().GetAddress()), "", 5, 3)},
+		{"inputs and outputs", Transaction{
+			Version: txVersion,
+			Vin: []TXInput{
+				{Txid: bytes.Repeat([]byte{0xab}, 32), Vout: 0, ScriptSig: []byte{1, 2, 3}, Sequence: 0xffffffff},
+				{Txid: bytes.Repeat([]byte{0xcd}, 32), Vout: 300, Sequence: 10},
+			},
+			Vout: []TXOutput{
+				{Value: 0, ScriptPubKey: []byte{OP_RETURN}},
+				{Value: 1 << 40, ScriptPubKey: bytes.Repeat([]byte{0x51}, 200)},
+			},
+			LockTime: 500000000,
+		}},
+		{"negative values", Transaction{
+			Version: txVersion,
+			Vin:     []TXInput{{Vout: -1}},
+			Vout:    []TXOutput{{Value: -7}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tx.ID = tt.tx.CalcID()
+			data := tt.tx.Serialize()
+
+			got, err := decodeTransaction(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameTransaction(&got, &tt.tx) {
+				t.Errorf("decodeTransaction() = %+v, want %+v", got, tt.tx)
+			}
+			if !bytes.Equal(got.Serialize(), data) {
+				t.Error("serialization is not stable after a round trip")
+			}
+		})
+	}
+}
+
+func TestBlockSerializeRoundTrip(t *testing.T) {
+	tx := newSpendTx(NewCoinbaseTX(string(NewWallet().GetAddress()), "", 0, 0), 0, NewWallet(), string(NewWallet().GetAddress()))
+	block := NewBlock([]*Transaction{NewCoinbaseTX(string(NewWallet().GetAddress()), "", 1, 0), tx}, bytes.Repeat([]byte{1}, 32), 1, 0x1f040000, 1700000000)
+	block.Nonce = 12345
+	block.SignerPubKey = []byte{4, 5, 6}
+	block.Signature = []byte{7, 8, 9}
+	block.Hash = block.CalcHash()
+
+	data := block.Serialize()
+	got, err := decodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.BlockHeader, block.BlockHeader) || !bytes.Equal(got.Hash, block.Hash) ||
+		!bytes.Equal(got.SignerPubKey, block.SignerPubKey) || !bytes.Equal(got.Signature, block.Signature) {
+		t.Errorf("decodeBlock() = %+v, want %+v", got, block)
+	}
+	if len(got.Transactions) != len(block.Transactions) {
+		t.Fatalf("decodeBlock() has %d transactions, want %d", len(got.Transactions), len(block.Transactions))
+	}
+	for i := range got.Transactions {
+		if !sameTransaction(got.Transactions[i], block.Transactions[i]) {
+			t.Errorf("transaction %d = %+v, want %+v", i, got.Transactions[i], block.Transactions[i])
+		}
+	}
+	if !bytes.Equal(got.Serialize(), data) {
+		t.Error("serialization is not stable after a round trip")
+	}
+}
+
+func TestDecodeTransactionRejectsNonCanonical(t *testing.T) {
+	valid := Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: []byte{1}, Vout: 0}},
+		Vout:    []TXOutput{{Value: 1}},
+	}.Serialize()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		// Version 1 编码为 0x81 0x00，不是最短的编码
+		{"non-minimal varint", append([]byte{0x81, 0x00}, valid[1:]...)},
+		{"trailing bytes", append(append([]byte{}, valid...), 0)},
+		{"truncated", valid[:len(valid)-1]},
+		{"length exceeds data", []byte{1, 1, 0xff, 0x01}},
+		{"varint overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"version overflows uint32", []byte{0x80, 0x80, 0x80, 0x80, 0x10, 0, 0, 0}},
+	}
+
+	if _, err := decodeTransaction(valid); err != nil {
+		t.Fatalf("valid transaction: %s", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeTransaction(tt.data); err == nil {
+				t.Error("decodeTransaction() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDecodeBlockRejectsTrailingBytes(t *testing.T) {
+	data := NewGenesisBlock(activeNet).Serialize()
+
+	if _, err := decodeBlock(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeBlock(append(data, 0)); err != errTrailingData {
+		t.Errorf("decodeBlock() error = %v, want %v", err, errTrailingData)
+	}
+}
+
+func TestTransactionCheckVersion(t *testing.T) {
+	tests := []struct {
+		version uint32
+		wantErr bool
+	}{
+		{txVersionLegacy, true},
+		{txVersion, false},
+		{txVersion + 1, true},
+	}
+
+	for _, tt := range tests {
+		tx := Transaction{Version: tt.version}
+		if err := tx.checkVersion(); (err != nil) != tt.wantErr {
+			t.Errorf("checkVersion() with version %d: error = %v, wantErr %v", tt.version, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+)
+
+const (
+	protocol      = "tcp"
+	nodeVersion   = 2
+	commandLength = 12 // 消息头中命令的长度
+)
+
+// Server 网络节点
+// 每条消息使用一个TCP连接发送，格式为 12字节的命令 + gob编码的消息体
+type Server struct {
+	nodeAddress     string
+	minerAddress    string // 不为空时，节点将内存池中的交易打包挖矿
+	bc              *Blockchain
+	mu              sync.Mutex
+	knownNodes      []string
+	blocksInTransit [][]byte // 等待下载的区块，按从旧到新排列
+	mempool         *Mempool
+	cancelMining    context.CancelFunc // 正在后台挖矿时不为nil，调用后中止挖矿
+}
+
+// versionMsg 握手消息，交换双方的网络与链高度
+type versionMsg struct {
+	Version    int
+	Network    string
+	BestHeight int
+	AddrFrom   string
+}
+
+// getBlocksMsg 请求对方链中所有区块的hash
+type getBlocksMsg struct {
+	AddrFrom string
+}
+
+// invMsg 告知对方本节点拥有的区块或交易
+// Type 为 "block" 或 "tx"
+type invMsg struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+// getDataMsg 请求一个区块或交易
+type getDataMsg struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+// blockMsg 发送一个序列化后的区块
+type blockMsg struct {
+	AddrFrom string
+	Block    []byte
+}
+
+// txMsg 发送一个序列化后的交易
+type txMsg struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// NewServer 创建一个节点
+// seeds 为启动时连接的节点地址
+func NewServer(nodePort, minerAddress string, seeds []string, bc *Blockchain) *Server {
+	s := &Server{
+		nodeAddress:  fmt.Sprintf("localhost:%s", nodePort),
+		minerAddress: minerAddress,
+		bc:           bc,
+		mempool:      NewMempool(&UTXOSet{bc}, bc.db),
+	}
+
+	for _, seed := range seeds {
+		if seed != "" && seed != s.nodeAddress {
+			s.knownNodes = append(s.knownNodes, seed)
+		}
+	}
+
+	return s
+}
+
+// Start 监听端口，向已知节点发送version并处理收到的消息
+func (s *Server) Start() {
+	ln, err := net.Listen(protocol, s.nodeAddress)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer ln.Close()
+
+	log.Printf("Node %s started, best height %d\n", s.nodeAddress, s.bc.GetBestHeight())
+
+	for _, node := range s.peers() {
+		s.sendVersion(node)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Panic(err)
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection 读取并分发一条消息
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Failed to handle message from %s: %v\n", conn.RemoteAddr(), r)
+		}
+	}()
+
+	request, err := ioutil.ReadAll(conn)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(request) < commandLength {
+		return
+	}
+
+	command := bytesToCommand(request[:commandLength])
+	payload := request[commandLength:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch command {
+	case "version":
+		s.handleVersion(payload)
+	case "getblocks":
+		s.handleGetBlocks(payload)
+	case "inv":
+		s.handleInv(payload)
+	case "getdata":
+		s.handleGetData(payload)
+	case "block":
+		s.handleBlock(payload)
+	case "tx":
+		s.handleTx(payload)
+	default:
+		log.Printf("Unknown command %q\n", command)
+	}
+}
+
+func (s *Server) handleVersion(payload []byte) {
+	var msg versionMsg
+	gobDecode(payload, &msg)
+
+	// 不同网络的节点互不连接
+	if msg.Network != activeNet.Name {
+		log.Printf("Ignoring node %s on network %q\n", msg.AddrFrom, msg.Network)
+		return
+	}
+	// 版本2开始区块与交易使用规范二进制格式传输，无法解析旧版本节点的消息
+	if msg.Version != nodeVersion {
+		log.Printf("Ignoring node %s with version %d\n", msg.AddrFrom, msg.Version)
+		return
+	}
+
+	myBestHeight := s.bc.GetBestHeight()
+	if myBestHeight < msg.BestHeight {
+		s.sendGetBlocks(msg.AddrFrom)
+	} else if myBestHeight > msg.BestHeight {
+		s.sendVersion(msg.AddrFrom)
+	}
+
+	s.addNode(msg.AddrFrom)
+}
+
+func (s *Server) handleGetBlocks(payload []byte) {
+	var msg getBlocksMsg
+	gobDecode(payload, &msg)
+
+	blocks := s.bc.GetBlockHashes()
+	s.sendInv(msg.AddrFrom, "block", blocks)
+}
+
+func (s *Server) handleInv(payload []byte) {
+	var msg invMsg
+	gobDecode(payload, &msg)
+
+	log.Printf("Received inventory with %d %s\n", len(msg.Items), msg.Type)
+
+	switch msg.Type {
+	case "block":
+		// Items 从新到旧排列，从最旧的缺失区块开始下载
+		s.blocksInTransit = nil
+		for i := len(msg.Items) - 1; i >= 0; i-- {
+			if !s.bc.HasBlock(msg.Items[i]) {
+				s.blocksInTransit = append(s.blocksInTransit, msg.Items[i])
+			}
+		}
+
+		if len(s.blocksInTransit) > 0 {
+			s.sendGetData(msg.AddrFrom, "block", s.blocksInTransit[0])
+			s.blocksInTransit = s.blocksInTransit[1:]
+		}
+	case "tx":
+		for _, txID := range msg.Items {
+			if !s.mempool.Has(txID) {
+				s.sendGetData(msg.AddrFrom, "tx", txID)
+			}
+		}
+	}
+}
+
+func (s *Server) handleGetData(payload []byte) {
+	var msg getDataMsg
+	gobDecode(payload, &msg)
+
+	switch msg.Type {
+	case "block":
+		block, err := s.bc.GetBlock(msg.ID)
+		if err != nil {
+			return
+		}
+		s.sendBlock(msg.AddrFrom, &block)
+	case "tx":
+		tx, ok := s.mempool.Get(msg.ID)
+		if !ok {
+			return
+		}
+		s.sendTx(msg.AddrFrom, &tx)
+	}
+}
+
+func (s *Server) handleBlock(payload []byte) {
+	var msg blockMsg
+	gobDecode(payload, &msg)
+
+	block := DeserializeBlock(msg.Block)
+	log.Printf("Received block %x\n", block.Hash)
+
+	if len(block.PrevBlockHash) != 0 && !s.bc.HasBlock(block.PrevBlockHash) {
+		// 缺少父区块，向对方请求所有区块
+		s.sendGetBlocks(msg.AddrFrom)
+		return
+	}
+
+	oldTip := s.bc.tip
+	err := s.bc.AddBlock(block)
+	if err != nil {
+		log.Printf("Rejected block %x: %s\n", block.Hash, err)
+		return
+	}
+
+	if !bytes.Equal(oldTip, s.bc.tip) {
+		log.Printf("Added block %x, best height %d\n", block.Hash, s.bc.GetBestHeight())
+
+		// 正在挖的区块的父区块已经不是tip，中止之后在新的tip上重新开始
+		if s.cancelMining != nil {
+			s.cancelMining()
+		}
+
+		s.mempool.RemoveBlockTransactions(block)
+		if !bytes.Equal(block.PrevBlockHash, oldTip) {
+			s.mempool.Revalidate(&UTXOSet{s.bc})
+		}
+		if len(s.blocksInTransit) == 0 {
+			s.broadcastInv("block", [][]byte{block.Hash}, msg.AddrFrom)
+		}
+
+		// 同步完成之后在新的tip上打包剩余的交易，例如PoA中上一个区块轮到其他节点签名时
+		if s.minerAddress != "" && len(s.blocksInTransit) == 0 {
+			s.mineMempool()
+		}
+	}
+
+	if len(s.blocksInTransit) > 0 {
+		s.sendGetData(msg.AddrFrom, "block", s.blocksInTransit[0])
+		s.blocksInTransit = s.blocksInTransit[1:]
+	}
+}
+
+func (s *Server) handleTx(payload []byte) {
+	var msg txMsg
+	gobDecode(payload, &msg)
+
+	tx := DeserializeTransaction(msg.Transaction)
+	if s.mempool.Has(tx.ID) {
+		return
+	}
+
+	err := s.mempool.Add(tx, &UTXOSet{s.bc})
+	if err != nil {
+		log.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return
+	}
+	log.Printf("Received transaction %x, %d in mempool\n", tx.ID, s.mempool.Count())
+
+	s.broadcastInv("tx", [][]byte{tx.ID}, msg.AddrFrom)
+
+	if s.minerAddress != "" {
+		s.mineMempool()
+	}
+}
+
+// mineMempool 在后台将交易池中的交易打包成区块并广播，调用时需要持有s.mu
+// 工作量证明在不持有s.mu时进行，期间可以处理其他消息；收到使tip改变的区块时中止并重新开始
+func (s *Server) mineMempool() {
+	if s.cancelMining != nil {
+		return
+	}
+
+	txs := s.mempool.Transactions(0, &UTXOSet{s.bc})
+	if len(txs) == 0 {
+		return
+	}
+
+	newBlock := s.bc.NewBlockTemplate(s.minerAddress, txs)
+	if len(newBlock.Transactions) == 1 {
+		// 没有交易可以放入区块时不挖只有coinbase的区块
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelMining = cancel
+
+	go func() {
+		err := activeEngine.Seal(ctx, newBlock)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		aborted := ctx.Err() != nil
+		cancel()
+		s.cancelMining = nil
+
+		switch {
+		case aborted:
+			log.Printf("Mining of block at height %d aborted\n", newBlock.Height)
+		case err != nil:
+			// 无法封装区块时(例如PoA中轮不到本节点签名)不再重试，等待下一个交易或区块
+			log.Printf("Failed to mine block at height %d: %s\n", newBlock.Height, err)
+			return
+		default:
+			err = s.bc.AddBlock(newBlock)
+			if err != nil {
+				log.Printf("Rejected mined block %x: %s\n", newBlock.Hash, err)
+				return
+			}
+			log.Printf("Mined block %x with %d transactions\n", newBlock.Hash, len(newBlock.Transactions)-1)
+			s.mempool.RemoveBlockTransactions(newBlock)
+			s.broadcastInv("block", [][]byte{newBlock.Hash}, "")
+		}
+
+		// 挖矿期间收到的交易或中止之后剩余的交易
+		s.mineMempool()
+	}()
+}
+
+func (s *Server) sendVersion(addr string) {
+	payload := gobEncode(versionMsg{nodeVersion, activeNet.Name, s.bc.GetBestHeight(), s.nodeAddress})
+	s.sendData(addr, append(commandToBytes("version"), payload...))
+}
+
+func (s *Server) sendGetBlocks(addr string) {
+	payload := gobEncode(getBlocksMsg{s.nodeAddress})
+	s.sendData(addr, append(commandToBytes("getblocks"), payload...))
+}
+
+func (s *Server) sendInv(addr, kind string, items [][]byte) {
+	payload := gobEncode(invMsg{s.nodeAddress, kind, items})
+	s.sendData(addr, append(commandToBytes("inv"), payload...))
+}
+
+func (s *Server) sendGetData(addr, kind string, id []byte) {
+	payload := gobEncode(getDataMsg{s.nodeAddress, kind, id})
+	s.sendData(addr, append(commandToBytes("getdata"), payload...))
+}
+
+func (s *Server) sendBlock(addr string, b *Block) {
+	payload := gobEncode(blockMsg{s.nodeAddress, b.Serialize()})
+	s.sendData(addr, append(commandToBytes("block"), payload...))
+}
+
+func (s *Server) sendTx(addr string, tx *Transaction) {
+	payload := gobEncode(txMsg{s.nodeAddress, tx.Serialize()})
+	s.sendData(addr, append(commandToBytes("tx"), payload...))
+}
+
+// broadcastInv 向除except外的所有已知节点发送inv
+func (s *Server) broadcastInv(kind string, items [][]byte, except string) {
+	for _, node := range s.peers() {
+		if node != except {
+			s.sendInv(node, kind, items)
+		}
+	}
+}
+
+// sendData 向addr发送数据，连接失败时将其从已知节点中移除
+func (s *Server) sendData(addr string, data []byte) {
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		log.Printf("%s is not available\n", addr)
+		s.removeNode(addr)
+		return
+	}
+	defer conn.Close()
+
+	_, err = io.Copy(conn, bytes.NewReader(data))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) peers() []string {
+	return append([]string{}, s.knownNodes...)
+}
+
+func (s *Server) addNode(addr string) {
+	if addr == s.nodeAddress {
+		return
+	}
+	for _, node := range s.knownNodes {
+		if node == addr {
+			return
+		}
+	}
+	s.knownNodes = append(s.knownNodes, addr)
+}
+
+func (s *Server) removeNode(addr string) {
+	var nodes []string
+	for _, node := range s.knownNodes {
+		if node != addr {
+			nodes = append(nodes, node)
+		}
+	}
+	s.knownNodes = nodes
+}
+
+// SendTransaction 将交易发送给addr对应的节点
+func SendTransaction(addr string, tx *Transaction) {
+	s := &Server{}
+	s.sendTx(addr, tx)
+}
+
+// commandToBytes 将命令转换为固定长度的字节数组
+func commandToBytes(command string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range command {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+// bytesToCommand 将字节数组转换为命令
+func bytesToCommand(bytes []byte) string {
+	var command []byte
+
+	for _, b := range bytes {
+		if b != 0x0 {
+			command = append(command, b)
+		}
+	}
+
+	return string(command)
+}
+
+func gobEncode(data interface{}) []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func gobDecode(data []byte, v interface{}) {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(v)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// BlockSubsidy 返回高度为height的区块的挖矿奖励
+// 每SubsidyHalvingInterval个区块减半，累计发行量达到MaxSupply之后为0，见ChainParams
+func BlockSubsidy(height int) int {
+	return ScheduledSupply(height) - ScheduledSupply(height-1)
+}
+
+// ScheduledSupply 返回高度0到height的区块的挖矿奖励之和，不超过MaxSupply
+func ScheduledSupply(height int) int {
+	supply := 0
+
+	for era := 0; era < 63; era++ {
+		start := era * activeNet.SubsidyHalvingInterval
+		reward := activeNet.InitialSubsidy >> uint(era)
+		if start > height || reward == 0 {
+			break
+		}
+
+		end := start + activeNet.SubsidyHalvingInterval - 1
+		if end > height {
+			end = height
+		}
+		supply += (end - start + 1) * reward
+	}
+
+	if supply > activeNet.MaxSupply {
+		supply = activeNet.MaxSupply
+	}
+
+	return supply
+}
+
+// IsMature 输出是否可以在高度为height的区块中使用
+// coinbase交易的输出需要等待CoinbaseMaturity个区块
+func (c confirmation) IsMature(height int) bool {
+	return !c.Coinbase || height-c.Height >= activeNet.CoinbaseMaturity
+}
@@ -1,46 +1,79 @@
 package main
 
 import (
-	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
-	"math/big"
+	"math"
 )
 
-const subsidy = 10 // subsidu 发币量
+const (
+	txVersionLegacy = 0 // 旧版本的交易，hash为gob编码的sha256，见legacy.go
+	txVersion       = 1 // hash为规范二进制格式的sha256，见serialize.go
+)
 
 type Transaction struct {
-	ID   []byte     // 交易ID
-	Vin  []TXInput  // 交易的输入集
-	Vout []TXOutput // 交易的输出集
+	Version  uint32     // 交易版本，决定交易hash的计算方式
+	ID       []byte     // 交易ID
+	Vin      []TXInput  // 交易的输入集
+	Vout     []TXOutput // 交易的输出集
+	LockTime uint32     // 交易可以被打包的最小区块高度或时间戳，0表示不限制
 }
 
 // Trimmed 创建用于签名的交易的修剪副本
-// 输入置空了Signature和PubKey
+// 输入置空了ScriptSig，LockTime与Sequence保留，同样被签名
 func (tx *Transaction) Trimmed() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.Version, tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
 
-// Verify 验证交易输入的签名
+// SignatureHash 返回第inID个输入需要签名的数据
+// 修剪副本中该输入的ScriptSig设置为所引用输出的锁定脚本，其余输入的ScriptSig为空
+// 签名数据同时包含所有输入引用的输出的金额，离线签名时金额被篡改的交易签名无效，签名方看到的手续费即实际的手续费
+// 旧版本的交易签名时不包含金额，保证转换格式后的db中已有的签名仍然有效
+// prevOuts 为每个输入引用的输出，与Vin一一对应
+func (tx *Transaction) SignatureHash(inID int, prevOuts []TXOutput) []byte {
+	txCopy := tx.Trimmed()
+	txCopy.Vin[inID].ScriptSig = prevOuts[inID].ScriptPubKey
+
+	data := txCopy.Hash()
+	if tx.Version == txVersionLegacy {
+		return data
+	}
+	for _, out := range prevOuts {
+		data = append(data, IntToHex(int64(out.Value))...)
+	}
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// prevOutputs 返回每个输入引用的输出，与Vin一一对应
+func (tx *Transaction) prevOutputs(prevTXs map[string]Transaction) []TXOutput {
+	var prevOuts []TXOutput
+	for _, vin := range tx.Vin {
+		prevOuts = append(prevOuts, prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout])
+	}
+
+	return prevOuts
+}
+
+// Verify 验证交易输入的签名与输入输出的金额
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -52,42 +85,79 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.Trimmed()
-	curve := elliptic.P256()
+	if !tx.verifyValues(prevTXs) {
+		return false
+	}
 
+	// 依次执行每个输入的解锁脚本与所引用输出的锁定脚本
+	prevOuts := tx.prevOutputs(prevTXs)
 	for inID, vin := range tx.Vin {
+		sigHash := tx.SignatureHash(inID, prevOuts)
+
+		err := VerifyScript(vin.ScriptSig, prevOuts[inID].ScriptPubKey, sigHash)
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyValues 验证交易的金额
+// 输出金额不能为负数，输入不能重复引用同一个输出，输入总额不能小于输出总额
+func (tx *Transaction) verifyValues(prevTXs map[string]Transaction) bool {
+	inputs := 0
+	spent := make(map[string]bool)
+
+	for _, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Vin[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{
-			Curve: curve,
-			X:     &x,
-			Y:     &y,
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+
+		key := outpointKey(vin.Txid, vin.Vout)
+		if spent[key] {
+			return false
 		}
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) {
+		spent[key] = true
+
+		value := prevTx.Vout[vin.Vout].Value
+		if value < 0 || inputs > math.MaxInt-value {
 			return false
 		}
+		inputs += value
 	}
 
-	return true
+	outputs := 0
+	for _, vout := range tx.Vout {
+		if vout.Value < 0 || outputs > math.MaxInt-vout.Value {
+			return false
+		}
+		outputs += vout.Value
+	}
+
+	return inputs >= outputs
+}
+
+// Fee 返回交易的手续费，即输入总额与输出总额的差
+// prevTXs 交易输入所引用的交易的集合
+func (tx *Transaction) Fee(prevTXs map[string]Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	fee := 0
+	for _, vin := range tx.Vin {
+		fee += prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout].Value
+	}
+	for _, vout := range tx.Vout {
+		fee -= vout.Value
+	}
+
+	return fee
 }
 
-// Sign 签署每个输入的交易
+// Sign 签署每个输入的交易，输入引用的输出都应当是支付给privKey的P2PKH输出
 // prevTXs 需要签署的交易的输入的集合
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
 	// coinbase 交易因为没有实际输入，所以没有被签名。
@@ -101,85 +171,137 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.Trimmed()
+	keyLen := (privKey.Curve.Params().BitSize + 7) / 8
+	pubKey := publicKeyBytes(&privKey.PublicKey, keyLen)
+
+	prevOuts := tx.prevOutputs(prevTXs)
+	sigHashes := make([][]byte, len(tx.Vin))
+	for inID := range tx.Vin {
+		sigHashes[inID] = tx.SignatureHash(inID, prevOuts)
+	}
 
-	for inID, vin := range txCopy.Vin {
-		prevTX := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		// 输入的PubKey设置为所引用输出的PubKeyHash
-		txCopy.Vin[inID].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Vin[inID].PubKey = nil
+	for inID, sigHash := range sigHashes {
+		tx.Vin[inID].ScriptSig = NewP2PKHScriptSig(signHash(privKey, sigHash), pubKey)
+	}
+}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		if err != nil {
-			log.Panic(err)
-		}
-		signature := append(r.Bytes(), s.Bytes()...)
-		tx.Vin[inID].Signature = signature
+// signHash 使用私钥签名hash
+// r、s 补齐到曲线的字节长度，保证验证时可以从中间切分
+func signHash(privKey ecdsa.PrivateKey, hash []byte) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		log.Panic(err)
 	}
+	keyLen := (privKey.Curve.Params().BitSize + 7) / 8
+
+	return append(r.FillBytes(make([]byte, keyLen)), s.FillBytes(make([]byte, keyLen))...)
 }
 
-// Serialize 返回一个序列化的交易
+// Serialize 返回规范二进制格式的交易，不包含交易ID
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	var w canonicalWriter
+	tx.encode(&w)
+
+	return w.buf.Bytes()
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+// DeserializeTransaction 反序列化交易并计算交易ID
+func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := decodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return encoded.Bytes()
+	return transaction
 }
 
 // Hash 返回交易的Hash
+// 旧版本的交易使用gob编码计算，保证已有交易的ID与签名仍然有效
 func (tx *Transaction) Hash() []byte {
+	if tx.Version == txVersionLegacy {
+		return legacyTxHash(tx)
+	}
+
 	hash := sha256.Sum256(tx.Serialize())
 	return hash[:]
 }
 
+// CalcID 计算交易ID
+// ID为置空ID与解锁脚本之后的交易的hash，签名不改变交易ID；coinbase交易保留输入中的数据
+func (tx *Transaction) CalcID() []byte {
+	txCopy := tx.Trimmed()
+	txCopy.ID = nil
+	if tx.IsCoinbase() {
+		txCopy.Vin[0].ScriptSig = tx.Vin[0].ScriptSig
+	}
+
+	return txCopy.Hash()
+}
+
+// checkVersion 检查新的交易使用当前版本
+// 旧版本的交易只出现在migrateDB转换的已有区块中，交易池与新的区块都不接受
+func (tx *Transaction) checkVersion() error {
+	if tx.Version != txVersion {
+		return fmt.Errorf("Transaction %x has unsupported version %d", tx.ID, tx.Version)
+	}
+
+	return nil
+}
+
 // IsCoinbase 检查交易是否是 coinbase
 func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
 // NewCoinbaseTX 创建一个coinbase交易
-func NewCoinbaseTX(to, data string) *Transaction {
+// 输出金额为高度为height的区块的挖矿奖励加上区块中交易的手续费fees
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
 	if data == "" {
-		data = fmt.Sprintf("Reward to '%s'", to)
+		// 区块高度保证同一地址的coinbase交易ID不同
+		data = fmt.Sprintf("height %d", height)
 	}
 
 	txin := TXInput{
 		Txid:      []byte{},
 		Vout:      -1,
-		Signature: nil,
-		PubKey:    []byte(data),
+		ScriptSig: []byte(data),
 	}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(BlockSubsidy(height)+fees, to)
 	tx := Transaction{
-		ID:   nil,
-		Vin:  []TXInput{txin},
-		Vout: []TXOutput{*txout},
+		Version: txVersion,
+		ID:      nil,
+		Vin:     []TXInput{txin},
+		Vout:    []TXOutput{*txout},
 	}
-	tx.ID = tx.Hash()
+	tx.ID = tx.CalcID()
 	return &tx
 }
 
 // NewUTXOTransaction 创建一个新交易
-func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) *Transaction {
+// 输入总额与输出总额的差额fee作为手续费支付给矿工
+// mempool不为nil时，不使用已经被池中交易引用的输出
+// wallet 为付款方的钱包，必须包含私钥
+// lockTime 与 sequence 分别设置为交易的LockTime与每个输入的Sequence，见locktime.go
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, lockTime, sequence uint32, UTXOSet *UTXOSet, mempool *Mempool) *Transaction {
+	if wallet.PrivateKey.D == nil {
+		log.Panic("ERROR: Wallet is locked")
+	}
+
+	tx := NewUnsignedTransaction(string(wallet.GetAddress()), to, amount, fee, UTXOSet, mempool)
+	tx.SetLockTime(lockTime, sequence)
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+	return tx
+}
+
+// NewUnsignedTransaction 创建一个从from地址支付给to的未签名交易，找零支付给from
+// 输入的ScriptSig为空，需要签名后才能使用
+func NewUnsignedTransaction(from, to string, amount, fee int, UTXOSet *UTXOSet, mempool *Mempool) *Transaction {
 	var inputs []TXInput
 	var outpusts []TXOutput
 
-	wallets, err := NewWallets()
-	if err != nil {
-		log.Panic(err)
-	}
-	wallet := wallets.GetWallet(from)
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(addressToScript(from), amount+fee, mempool)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("ERROR: Not enough funds")
 	}
 	// 这里并未按需取未使用输出，而是将所有未使用输出作为当前交易的输入
@@ -191,24 +313,23 @@ func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) *Transactio
 
 		for _, out := range outs {
 			input := TXInput{
-				Txid:   txID,
-				Vout:   out,
-				PubKey: wallet.PublicKey,
+				Txid: txID,
+				Vout: out,
 			}
 			inputs = append(inputs, input)
 		}
 	}
 	// 一个地址的未使用的多个输出最终演变为一个未使用输出，提高了效率
 	outpusts = append(outpusts, *NewTXOutput(amount, to))
-	if acc > amount {
-		outpusts = append(outpusts, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		outpusts = append(outpusts, *NewTXOutput(acc-amount-fee, from))
 	}
 	tx := Transaction{
-		ID:   nil,
-		Vin:  inputs,
-		Vout: outpusts,
+		Version: txVersion,
+		ID:      nil,
+		Vin:     inputs,
+		Vout:    outpusts,
 	}
-	tx.ID = tx.Hash()
-	bc.SignTransaction(&tx, wallet.PrivateKey)
+	tx.ID = tx.CalcID()
 	return &tx
 }
@@ -1,30 +1,45 @@
 package main
 
-import "bytes"
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+)
 
 type TXOutput struct {
-	Value      int    // 输出的值
-	PubKeyHash []byte // 公钥产生的hash
+	Value        int    // 输出的值
+	ScriptPubKey []byte // 锁定脚本，使用输出时需要提供使其执行成功的解锁脚本
 }
 
 // Lock 签署输出
-// 从地址中获取公钥的hash
+// 根据地址的版本号生成P2PKH或P2SH锁定脚本
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	out.ScriptPubKey = addressToScript(string(address))
 }
 
-// IsLockedWithKey 检查输出是否可以被 pubkey 的所有者使用
+// IsLockedWithKey 检查输出是否是支付给pubKeyHash的P2PKH输出
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	lockingHash, ok := ExtractPubKeyHash(out.ScriptPubKey)
+	return ok && bytes.Equal(lockingHash, pubKeyHash)
+}
+
+// Address 返回标准锁定脚本对应的地址，非标准脚本返回空字符串
+func (out *TXOutput) Address() string {
+	if pubKeyHash, ok := ExtractPubKeyHash(out.ScriptPubKey); ok {
+		return pubKeyHashToAddress(pubKeyHash)
+	}
+	if scriptHash, ok := ExtractScriptHash(out.ScriptPubKey); ok {
+		return scriptHashToAddress(scriptHash)
+	}
+
+	return ""
 }
 
 // NewTXOutput 常见一个新的输出
 func NewTXOutput(value int, address string) *TXOutput {
 	txo := &TXOutput{
-		Value:      value,
-		PubKeyHash: nil,
+		Value:        value,
+		ScriptPubKey: nil,
 	}
 	txo.Lock([]byte(address))
 
@@ -33,14 +48,59 @@ func NewTXOutput(value int, address string) *TXOutput {
 
 type TXInput struct {
 	Txid      []byte // 一个输入引用了之前交易的一个输出,所引用的输出的交易的 ID
-	Vout      int // 引用的输出在其所在交易的索引
-	Signature []byte
-	PubKey    []byte
+	Vout      int    // 引用的输出在其所在交易的索引
+	ScriptSig []byte // 解锁脚本，coinbase交易的输入为任意数据
+	Sequence  uint32 // 相对锁定时间，编码方式见locktime.go
 }
 
 // UsesKey 检查pubKeyHash所有者是否发起了交易
+// 解锁脚本中最后压入的数据为公钥
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
-	// 比较pubKey与lockingHash
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+	ops, err := parseScript(in.ScriptSig)
+	if err != nil || len(ops) == 0 {
+		return false
+	}
+	lockingHash := HashPubKey(ops[len(ops)-1].Data)
+
+	return bytes.Equal(lockingHash, pubKeyHash)
+}
+
+// TXOutputs 输出的集合，用于在chainstate中存储一个交易的未使用输出
+// Height、Timestamp 为交易所在区块的高度与时间戳，用于检查相对锁定时间与coinbase成熟度
+type TXOutputs struct {
+	Outputs   map[int]TXOutput // 输出在交易中的索引 -> 输出
+	Height    int
+	Timestamp int64
+	Coinbase  bool // 是否为coinbase交易的输出
+}
+
+// confirmation 返回输出所在区块的高度与时间戳
+func (outs TXOutputs) confirmation() confirmation {
+	return confirmation{outs.Height, outs.Timestamp, outs.Coinbase}
+}
+
+// Serialize 序列化TXOutputs
+func (outs TXOutputs) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(outs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeOutputs 反序列化TXOutputs
+func DeserializeOutputs(data []byte) TXOutputs {
+	var outputs TXOutputs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outputs
 }
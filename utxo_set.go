@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	utxoBucket = "chainstate"
+	undoBucket = "undo" // 区块hash -> 区块使用的输出
+)
+
+// UTXOSet 未使用输出集合
+// 以交易ID为key，将交易中所有未使用的输出存储在chainstate中，避免每次查询都遍历整条链
+type UTXOSet struct {
+	Blockchain *Blockchain
+}
+
+// FindSpendableOutputs 查找锁定脚本为scriptPubKey的未使用输出以在输入中引用
+// mempool不为nil时，跳过已经被池中交易引用的输出；跳过在下一个区块中还未成熟的coinbase输出
+func (u UTXOSet) FindSpendableOutputs(scriptPubKey []byte, amount int, mempool *Mempool) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+	db := u.Blockchain.db
+	nextHeight := u.Blockchain.GetBestHeight() + 1
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			txID := hex.EncodeToString(k)
+			outs := DeserializeOutputs(v)
+			if !outs.confirmation().IsMature(nextHeight) {
+				continue
+			}
+
+			for outIdx, out := range outs.Outputs {
+				if mempool != nil && mempool.IsSpent(k, outIdx) {
+					continue
+				}
+				if bytes.Equal(out.ScriptPubKey, scriptPubKey) && accumulated < amount {
+					accumulated += out.Value
+					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// FindUTXO 查找锁定脚本为scriptPubKey的所有未使用输出
+func (u UTXOSet) FindUTXO(scriptPubKey []byte) []TXOutput {
+	var UTXOs []TXOutput
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				if bytes.Equal(out.ScriptPubKey, scriptPubKey) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return UTXOs
+}
+
+// FindOutput 查找一个未使用的输出
+func (u UTXOSet) FindOutput(txid []byte, vout int) (TXOutput, bool) {
+	var output TXOutput
+	var found bool
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		outsBytes := b.Get(txid)
+		if outsBytes == nil {
+			return nil
+		}
+
+		output, found = DeserializeOutputs(outsBytes).Outputs[vout]
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return output, found
+}
+
+// FindConfirmations 返回交易每个输入所引用的输出所在区块的高度、时间戳以及是否为coinbase输出
+func (u UTXOSet) FindConfirmations(tx *Transaction) ([]confirmation, error) {
+	var confirmations []confirmation
+	db := u.Blockchain.db
+
+	err := db.View(func(dbtx *bolt.Tx) error {
+		b := dbtx.Bucket([]byte(utxoBucket))
+		for _, vin := range tx.Vin {
+			if b.Get(vin.Txid) == nil {
+				return fmt.Errorf("Input %x:%d is already spent or does not exist", vin.Txid, vin.Vout)
+			}
+		}
+		confirmations = utxoConfirmations(b, tx)
+
+		return nil
+	})
+
+	return confirmations, err
+}
+
+// TotalValue 返回未使用输出的金额之和
+// spendable 为当前流通的货币总量，unspendable 为锁定脚本以OP_RETURN开头、无法使用的输出的金额之和
+func (u UTXOSet) TotalValue() (spendable, unspendable int) {
+	db := u.Blockchain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			for _, out := range DeserializeOutputs(v).Outputs {
+				if IsUnspendable(out.ScriptPubKey) {
+					unspendable += out.Value
+				} else {
+					spendable += out.Value
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return spendable, unspendable
+}
+
+// CountTransactions 返回chainstate中包含未使用输出的交易数
+func (u UTXOSet) CountTransactions() int {
+	db := u.Blockchain.db
+	counter := 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			counter++
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return counter
+}
+
+// Reindex 从创世区块开始重放主链上的所有区块，重建chainstate、undo数据与高度索引
+func (u UTXOSet) Reindex() {
+	db := u.Blockchain.db
+	hashes := u.Blockchain.GetBlockHashes()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{utxoBucket, undoBucket, heightsBucket} {
+			if tx.Bucket([]byte(name)) != nil {
+				err := tx.DeleteBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+			_, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		for i := len(hashes) - 1; i >= 0; i-- {
+			block := DeserializeBlock(b.Get(hashes[i]))
+			err := connectBlock(tx, block)
+			if err != nil {
+				return fmt.Errorf("block %x: %s", block.Hash, err)
+			}
+			err = putHeight(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// spentOutput 被区块中的交易使用的输出，用于回滚区块
+type spentOutput struct {
+	Txid      []byte
+	Vout      int
+	Output    TXOutput
+	Height    int
+	Timestamp int64
+	Coinbase  bool
+}
+
+// connectBlock 在给定的bolt事务中将区块应用到chainstate
+// 按顺序验证区块中的每个交易及其锁定时间，移除被交易输入引用的输出，加入交易产生的新输出
+// coinbase交易的输出总额不能超过挖矿奖励与手续费之和，交易不能使用未成熟的coinbase输出
+// 被移除的输出作为undo数据保存，用于回滚区块
+func connectBlock(tx *bolt.Tx, block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+	ub, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	var spent []spentOutput
+	fees, minted := 0, 0
+
+	for _, btx := range block.Transactions {
+		if !btx.IsFinal(block.Height, block.Timestamp) {
+			return fmt.Errorf("Transaction %x is locked until %d", btx.ID, btx.LockTime)
+		}
+
+		if !btx.IsCoinbase() {
+			prevTXs, err := utxoPrevTransactions(b, btx)
+			if err != nil {
+				return err
+			}
+			if !btx.Verify(prevTXs) {
+				return fmt.Errorf("Invalid transaction %x", btx.ID)
+			}
+			err = btx.CheckSequenceLocks(block.Height, block.Timestamp, utxoConfirmations(b, btx))
+			if err != nil {
+				return fmt.Errorf("Transaction %x: %s", btx.ID, err)
+			}
+			fees += btx.Fee(prevTXs)
+
+			for _, vin := range btx.Vin {
+				outs := DeserializeOutputs(b.Get(vin.Txid))
+				if !outs.confirmation().IsMature(block.Height) {
+					return fmt.Errorf("Transaction %x spends immature coinbase output %x:%d", btx.ID, vin.Txid, vin.Vout)
+				}
+				spent = append(spent, spentOutput{vin.Txid, vin.Vout, outs.Outputs[vin.Vout], outs.Height, outs.Timestamp, outs.Coinbase})
+				delete(outs.Outputs, vin.Vout)
+
+				if len(outs.Outputs) == 0 {
+					err = b.Delete(vin.Txid)
+				} else {
+					err = b.Put(vin.Txid, outs.Serialize())
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		newOutputs := TXOutputs{
+			Outputs:   make(map[int]TXOutput),
+			Height:    block.Height,
+			Timestamp: block.Timestamp,
+			Coinbase:  btx.IsCoinbase(),
+		}
+		for outIdx, out := range btx.Vout {
+			newOutputs.Outputs[outIdx] = out
+			if btx.IsCoinbase() {
+				minted += out.Value
+			}
+		}
+
+		err = b.Put(btx.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	if minted > BlockSubsidy(block.Height)+fees {
+		return fmt.Errorf("Coinbase pays %d, more than subsidy %d plus fees %d", minted, BlockSubsidy(block.Height), fees)
+	}
+
+	return ub.Put(block.Hash, gobEncode(spent))
+}
+
+// disconnectBlock 在给定的bolt事务中从chainstate回滚区块
+// 按与connectBlock相反的顺序逐个回滚交易: 移除交易产生的输出，再恢复交易使用的输出
+// 交易可能使用同一区块中之前的交易产生的输出，这些输出在回滚之前的交易时一起被移除
+func disconnectBlock(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	ub := tx.Bucket([]byte(undoBucket))
+
+	undoData := ub.Get(block.Hash)
+	if undoData == nil {
+		return fmt.Errorf("Undo data of block %x is not found", block.Hash)
+	}
+	var spent []spentOutput
+	gobDecode(undoData, &spent)
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		btx := block.Transactions[i]
+		err := b.Delete(btx.ID)
+		if err != nil {
+			return err
+		}
+		if btx.IsCoinbase() {
+			continue
+		}
+
+		if len(spent) < len(btx.Vin) {
+			return fmt.Errorf("Undo data of block %x is incomplete", block.Hash)
+		}
+		txSpent := spent[len(spent)-len(btx.Vin):]
+		spent = spent[:len(spent)-len(btx.Vin)]
+
+		for j := len(txSpent) - 1; j >= 0; j-- {
+			outs := TXOutputs{
+				Outputs:   make(map[int]TXOutput),
+				Height:    txSpent[j].Height,
+				Timestamp: txSpent[j].Timestamp,
+				Coinbase:  txSpent[j].Coinbase,
+			}
+			if outsBytes := b.Get(txSpent[j].Txid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+			outs.Outputs[txSpent[j].Vout] = txSpent[j].Output
+
+			err := b.Put(txSpent[j].Txid, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(spent) != 0 {
+		return fmt.Errorf("Undo data of block %x does not match its transactions", block.Hash)
+	}
+
+	return ub.Delete(block.Hash)
+}
+
+// utxoPrevTransactions 从chainstate中查找交易输入引用的输出
+// 返回的交易只包含ID与被引用的输出，用于验证交易
+func utxoPrevTransactions(b *bolt.Bucket, tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		outsBytes := b.Get(vin.Txid)
+		if outsBytes == nil {
+			return nil, fmt.Errorf("Input %x:%d is already spent or does not exist", vin.Txid, vin.Vout)
+		}
+		out, ok := DeserializeOutputs(outsBytes).Outputs[vin.Vout]
+		if !ok {
+			return nil, fmt.Errorf("Input %x:%d is already spent or does not exist", vin.Txid, vin.Vout)
+		}
+
+		txID := hex.EncodeToString(vin.Txid)
+		prevTX, ok := prevTXs[txID]
+		if !ok {
+			prevTX = Transaction{ID: vin.Txid}
+		}
+		for len(prevTX.Vout) <= vin.Vout {
+			prevTX.Vout = append(prevTX.Vout, TXOutput{})
+		}
+		prevTX.Vout[vin.Vout] = out
+		prevTXs[txID] = prevTX
+	}
+
+	return prevTXs, nil
+}
+
+// utxoConfirmations 从chainstate中查找交易输入所引用的输出所在区块的高度、时间戳以及是否为coinbase输出
+// 输入引用的输出需要已经在chainstate中
+func utxoConfirmations(b *bolt.Bucket, tx *Transaction) []confirmation {
+	var confirmations []confirmation
+
+	for _, vin := range tx.Vin {
+		confirmations = append(confirmations, DeserializeOutputs(b.Get(vin.Txid)).confirmation())
+	}
+
+	return confirmations
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// newTestDB 在临时目录中创建一个bolt数据库
+func newTestDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), dbFile), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// newSpendTx 创建一个使用prev第vout个输出、将全部金额支付给to的已签名交易
+func newSpendTx(prev *Transaction, vout int, from *Wallet, to string) *Transaction {
+	tx := &Transaction{
+		Version: txVersion,
+		Vin:     []TXInput{{Txid: prev.ID, Vout: vout}},
+		Vout:    []TXOutput{*NewTXOutput(prev.Vout[vout].Value, to)},
+	}
+	tx.ID = tx.CalcID()
+	tx.Sign(from.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+
+	return tx
+}
+
+// newTestBlock 创建一个不需要封装的区块，connectBlock不检查工作量证明
+func newTestBlock(txs []*Transaction, prev *Block, height int) *Block {
+	var prevHash []byte
+	if prev != nil {
+		prevHash = prev.Hash
+	}
+	block := NewBlock(txs, prevHash, height, activeNet.PowLimitBits, 1700000000+int64(height))
+	block.Hash = block.CalcHash()
+
+	return block
+}
+
+// chainstate 返回chainstate中的所有未使用输出
+func chainstate(t *testing.T, tx *bolt.Tx) map[string]TXOutputs {
+	t.Helper()
+
+	state := make(map[string]TXOutputs)
+	err := tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+		state[hex.EncodeToString(k)] = DeserializeOutputs(v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func TestDisconnectBlock(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	aliceAddr, bobAddr := string(alice.GetAddress()), string(bob.GetAddress())
+
+	cb0 := NewCoinbaseTX(aliceAddr, "", 0, 0)
+	genesis := newTestBlock([]*Transaction{cb0}, nil, 0)
+
+	height := activeNet.CoinbaseMaturity
+	// spend2 使用同一区块中spend1产生的输出
+	spend1 := newSpendTx(cb0, 0, alice, aliceAddr)
+	spend2 := newSpendTx(spend1, 0, alice, bobAddr)
+
+	tests := []struct {
+		name string
+		txs  []*Transaction
+	}{
+		{"coinbase only", []*Transaction{NewCoinbaseTX(bobAddr, "", height, 0)}},
+		{"spend confirmed output", []*Transaction{NewCoinbaseTX(bobAddr, "", height, 0), spend1}},
+		{"spend output of the same block", []*Transaction{NewCoinbaseTX(bobAddr, "", height, 0), spend1, spend2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			err := db.Update(func(tx *bolt.Tx) error {
+				if err := connectBlock(tx, genesis); err != nil {
+					return err
+				}
+				before := chainstate(t, tx)
+
+				block := newTestBlock(tt.txs, genesis, height)
+				if err := connectBlock(tx, block); err != nil {
+					return err
+				}
+				if err := disconnectBlock(tx, block); err != nil {
+					return err
+				}
+
+				if after := chainstate(t, tx); !reflect.DeepEqual(before, after) {
+					t.Errorf("chainstate after disconnect = %v, want %v", after, before)
+				}
+				if tx.Bucket([]byte(undoBucket)).Get(block.Hash) != nil {
+					t.Error("undo data of the disconnected block is not deleted")
+				}
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
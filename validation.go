@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	maxBlockSize       = 1000000     // 序列化之后的区块大小上限，单位字节
+	maxFutureBlockTime = 2 * 60 * 60 // 区块时间戳最多超前当前时间多少秒
+	medianTimeBlocks   = 11          // 计算过去区块时间中位数使用的区块数
+)
+
+// ValidateBlock 验证区块能否加入链中，本地挖出的区块与从其他节点收到的区块都需要通过验证
+// 依赖UTXO集的检查(交易签名与金额、coinbase金额、锁定时间、coinbase成熟度)在区块连接到主链时由connectBlock完成
+// 新的区块中的交易必须使用当前版本，见Transaction.checkVersion
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	err := checkBlockSanity(block)
+	if err != nil {
+		return err
+	}
+	for _, tx := range block.Transactions {
+		if err := tx.checkVersion(); err != nil {
+			return err
+		}
+	}
+
+	return bc.checkBlockContext(block)
+}
+
+// checkBlockSanity 不依赖链的检查: 区块hash、时间戳、大小、coinbase、重复交易与区块内的双花
+func checkBlockSanity(block *Block) error {
+	if !bytes.Equal(block.Hash, block.CalcHash()) {
+		return errors.New("Block hash does not match the header")
+	}
+	if block.Timestamp > clock()+maxFutureBlockTime {
+		return errors.New("Block timestamp is too far in the future")
+	}
+	if len(block.Transactions) == 0 {
+		return errors.New("Block has no transactions")
+	}
+	if len(block.Serialize()) > maxBlockSize {
+		return errors.New("Block size exceeds the limit")
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return errors.New("Merkle root does not match transactions")
+	}
+
+	seen := make(map[string]bool)
+	spent := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() != (i == 0) {
+			return errors.New("Block must have exactly one coinbase transaction at index 0")
+		}
+		if !bytes.Equal(tx.ID, tx.CalcID()) {
+			return fmt.Errorf("Transaction %x has an incorrect ID", tx.ID)
+		}
+		if seen[string(tx.ID)] {
+			return fmt.Errorf("Duplicate transaction %x", tx.ID)
+		}
+		seen[string(tx.ID)] = true
+
+		if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+			return fmt.Errorf("Transaction %x has no inputs or outputs", tx.ID)
+		}
+		for _, out := range tx.Vout {
+			if out.Value < 0 {
+				return fmt.Errorf("Transaction %x has a negative output", tx.ID)
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			key := outpointKey(vin.Txid, vin.Vout)
+			if spent[key] {
+				return fmt.Errorf("Output %s is spent twice in the block", key)
+			}
+			spent[key] = true
+		}
+	}
+
+	return nil
+}
+
+// checkBlockContext 依赖父区块的检查: 父区块与高度、时间戳大于过去区块时间的中位数、共识引擎的封装(难度与工作量证明或签名)
+// 没有父区块的区块必须是当前网络的创世区块
+func (bc *Blockchain) checkBlockContext(block *Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		if len(bc.tip) != 0 || !isGenesisHash(block.Hash) {
+			return errors.New("Genesis block does not match")
+		}
+		if block.Height != 0 {
+			return errors.New("Incorrect block height")
+		}
+	} else {
+		prev, err := bc.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return errors.New("Previous block is not found")
+		}
+		if block.Height != prev.Height+1 {
+			return errors.New("Incorrect block height")
+		}
+		if block.Timestamp <= bc.MedianTimePast(block.PrevBlockHash) {
+			return errors.New("Block timestamp is not after the median time of previous blocks")
+		}
+	}
+
+	return bc.verifySeal(block)
+}
+
+// verifySeal 验证区块的封装，创世区块由hash识别，不需要共识引擎封装
+// 固定创世区块之前创建的链的创世区块与当前网络的不同，见Blockchain.genesisHash
+func (bc *Blockchain) verifySeal(block *Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		if !bytes.Equal(block.Hash, bc.genesisHash()) {
+			return errors.New("Genesis block does not match")
+		}
+		return nil
+	}
+
+	return activeEngine.VerifySeal(bc, block)
+}
+
+// MedianTimePast 返回以blockHash为tip的最近medianTimeBlocks个区块时间戳的中位数
+// 下一个区块的时间戳必须大于该值
+func (bc *Blockchain) MedianTimePast(blockHash []byte) int64 {
+	var timestamps []int64
+
+	for hash := blockHash; len(hash) != 0 && len(timestamps) < medianTimeBlocks; {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		timestamps = append(timestamps, block.Timestamp)
+		hash = block.PrevBlockHash
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/boltdb/bolt"
+)
+
+// VerifyChain 从tip向创世区块检查最近depth个区块，depth<=0时检查全部区块，返回检查的区块数与发现的第一个问题
+// level 0: 区块可以读取，hash与区块头一致，与父区块的连接、高度及高度索引正确
+// level 1: 区块的结构(checkBlockSanity)、时间戳与共识引擎的封装(难度与工作量证明或签名)
+// level 2: 交易的签名与金额
+// level 3: 从创世区块重放所有区块重建UTXO集，与chainstate比较
+func (bc *Blockchain) VerifyChain(level, depth int) (int, error) {
+	// 验证难度与签名需要读取更早的区块，level 0以上读取整条链
+	limit := 0
+	if level == 0 {
+		limit = depth
+	}
+	blocks, err := bc.readMainChain(limit)
+	if err != nil {
+		return 0, err
+	}
+	if depth <= 0 || depth > len(blocks) {
+		depth = len(blocks)
+	}
+
+	txs := make(map[string]Transaction)
+	if level >= 2 {
+		for _, block := range blocks {
+			for _, tx := range block.Transactions {
+				txs[hex.EncodeToString(tx.ID)] = *tx
+			}
+		}
+	}
+
+	for i, block := range blocks[:depth] {
+		if level >= 1 {
+			err = bc.verifyBlockHeader(block)
+		}
+		if err == nil && level >= 2 {
+			err = verifyBlockTransactions(block, txs)
+		}
+		if err != nil {
+			return i + 1, blockError(block, err)
+		}
+	}
+
+	if level >= 3 {
+		err = verifyUTXOSet(bc.db, blocks)
+	}
+
+	return depth, err
+}
+
+// readMainChain 从tip开始读取最多limit个主链区块(limit<=0时读取全部)，从新到旧排列
+// 同时检查区块的hash、与父区块的连接以及高度索引
+func (bc *Blockchain) readMainChain(limit int) ([]*Block, error) {
+	var blocks []*Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		hb := tx.Bucket([]byte(heightsBucket))
+		if b == nil || hb == nil {
+			return errors.New("Blocks or heights bucket is missing")
+		}
+
+		hash := b.Get([]byte("l"))
+		if hash == nil {
+			return errors.New("Tip of the chain is missing")
+		}
+
+		var child *Block
+		for len(hash) != 0 && (limit <= 0 || len(blocks) < limit) {
+			data := b.Get(hash)
+			if data == nil {
+				if child != nil {
+					return blockError(child, fmt.Errorf("Previous block %x is missing", hash))
+				}
+				return fmt.Errorf("Tip block %x is missing", hash)
+			}
+
+			// 区块hash由区块头计算
+			block, err := decodeBlock(data)
+			if err != nil {
+				return fmt.Errorf("Block %x cannot be decoded: %s", hash, err)
+			}
+
+			switch {
+			case !bytes.Equal(block.Hash, hash):
+				err = fmt.Errorf("Block is stored under a different hash %x", hash)
+			case child != nil && child.Height != block.Height+1:
+				err = fmt.Errorf("Height of the next block is %d", child.Height)
+			case len(block.PrevBlockHash) == 0 && block.Height != 0:
+				err = errors.New("Genesis block has a non-zero height")
+			case !bytes.Equal(hb.Get(IntToHex(int64(block.Height))), block.Hash):
+				err = errors.New("Height index does not point to the block")
+			}
+			if err != nil {
+				return blockError(block, err)
+			}
+
+			blocks = append(blocks, block)
+			child = block
+			hash = block.PrevBlockHash
+		}
+
+		return nil
+	})
+
+	return blocks, err
+}
+
+// verifyBlockHeader 检查区块的结构、时间戳与共识引擎的封装
+func (bc *Blockchain) verifyBlockHeader(block *Block) error {
+	err := checkBlockSanity(block)
+	if err != nil {
+		return err
+	}
+
+	if len(block.PrevBlockHash) != 0 && block.Timestamp <= bc.MedianTimePast(block.PrevBlockHash) {
+		return errors.New("Block timestamp is not after the median time of previous blocks")
+	}
+	return bc.verifySeal(block)
+}
+
+// verifyBlockTransactions 检查区块中交易的签名与金额，txs为主链上的所有交易
+func verifyBlockTransactions(block *Block, txs map[string]Transaction) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		prevTXs := make(map[string]Transaction)
+		for _, vin := range tx.Vin {
+			txID := hex.EncodeToString(vin.Txid)
+			prevTX, ok := txs[txID]
+			if !ok {
+				return fmt.Errorf("Transaction %x references unknown transaction %s", tx.ID, txID)
+			}
+			prevTXs[txID] = prevTX
+		}
+
+		if !tx.Verify(prevTXs) {
+			return fmt.Errorf("Invalid transaction %x", tx.ID)
+		}
+	}
+
+	return nil
+}
+
+// verifyUTXOSet 在临时db中从创世区块依次连接所有区块，将得到的UTXO集与db中的chainstate比较
+// blocks 为主链上的所有区块，从新到旧排列
+func verifyUTXOSet(db *bolt.DB, blocks []*Block) error {
+	file, err := ioutil.TempFile("", "verifychain-*.db")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	replayDB, err := bolt.Open(file.Name(), 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer replayDB.Close()
+
+	// 交易ID -> 交易所在的区块，用于报告UTXO集不一致的区块
+	txBlocks := make(map[string]*Block)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		err = replayDB.Update(func(tx *bolt.Tx) error {
+			return connectBlock(tx, block)
+		})
+		if err != nil {
+			return blockError(block, err)
+		}
+		for _, tx := range block.Transactions {
+			txBlocks[hex.EncodeToString(tx.ID)] = block
+		}
+	}
+
+	expected := readChainstate(replayDB)
+	actual := readChainstate(db)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			if !reflect.DeepEqual(expected[txID], actual[txID]) {
+				return blockError(blocks[i], fmt.Errorf("Unspent outputs of transaction %s do not match the chainstate", txID))
+			}
+		}
+	}
+	for txID := range actual {
+		if _, ok := txBlocks[txID]; !ok {
+			return fmt.Errorf("Chainstate has outputs of transaction %s which is not in the chain", txID)
+		}
+	}
+
+	return nil
+}
+
+// readChainstate 读取db中的chainstate，无法解码的记录Height为-1
+func readChainstate(db *bolt.DB) map[string]*TXOutputs {
+	utxos := make(map[string]*TXOutputs)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var outs TXOutputs
+			if gob.NewDecoder(bytes.NewReader(v)).Decode(&outs) != nil {
+				// 与不存在的记录区分
+				outs = TXOutputs{Height: -1}
+			}
+			utxos[hex.EncodeToString(k)] = &outs
+			return nil
+		})
+	})
+	if err != nil {
+		return nil
+	}
+
+	return utxos
+}
+
+func blockError(block *Block, err error) error {
+	return fmt.Errorf("Block %x at height %d: %s", block.Hash, block.Height, err)
+}
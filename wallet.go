@@ -11,10 +11,7 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
-const (
-	version            = byte(0x00)
-	addressChecksumLen = 4
-)
+const addressChecksumLen = 4
 
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey // 私钥
@@ -23,14 +20,14 @@ type Wallet struct {
 
 // GetAddress 生成钱包地址
 // 地址有version、pubkey、checksum构成
-// version 1字节
+// version 1字节，为当前网络的PubKeyHashAddrID
 // pubkeyhash  20字节
 // checksum 长度取决于addressChecksumLen，默认4字节
 func (w Wallet) GetAddress() []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
 
 	// 将1字节的版本号作为前缀加入
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	versionedPayload := append([]byte{activeNet.PubKeyHashAddrID}, pubKeyHash...)
 	// 生成公钥校验和
 	checksum := checksum(versionedPayload)
 
@@ -38,6 +35,43 @@ func (w Wallet) GetAddress() []byte {
 	return Base58Encode(fullPayload)
 }
 
+// pubKeyHashToAddress 由公钥hash生成地址
+func pubKeyHashToAddress(pubKeyHash []byte) string {
+	return encodeAddress(activeNet.PubKeyHashAddrID, pubKeyHash)
+}
+
+// scriptHashToAddress 由赎回脚本的hash生成P2SH地址
+func scriptHashToAddress(scriptHash []byte) string {
+	return encodeAddress(activeNet.ScriptHashAddrID, scriptHash)
+}
+
+// encodeAddress 生成 version + hash + checksum 的Base58编码
+func encodeAddress(version byte, hash []byte) string {
+	versionedPayload := append([]byte{version}, hash...)
+	fullPayload := append(versionedPayload, checksum(versionedPayload)...)
+
+	return string(Base58Encode(fullPayload))
+}
+
+// addressToPubKeyHash 由地址解析出公钥hash，P2SH地址为脚本hash
+// 地址需要先通过ValidateAddress校验
+func addressToPubKeyHash(address string) []byte {
+	pubKeyHash := Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
+// addressToScript 返回地址对应的锁定脚本，地址需要先通过ValidateAddress校验
+func addressToScript(address string) []byte {
+	payload := Base58Decode([]byte(address))
+	hash := payload[1 : len(payload)-addressChecksumLen]
+
+	if payload[0] == activeNet.ScriptHashAddrID {
+		return NewP2SHScript(hash)
+	}
+	return NewP2PKHScript(hash)
+}
+
 // Checksum 生成公钥的校验和
 // 两次sha256加密，取前addressChecksumLen个字节作为校验和
 func checksum(payload []byte) []byte {
@@ -64,13 +98,19 @@ func HashPubKey(pubKey []byte) []byte {
 }
 
 // ValidateAddress 检查地址是否有效
-// 通过checksum校验地址
+// 版本号需要是当前网络P2PKH或P2SH地址的版本号，并通过checksum校验地址
 func ValidateAddress(address string) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= 1+addressChecksumLen {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
+	addressVersion := pubKeyHash[0]
+	if addressVersion != activeNet.PubKeyHashAddrID && addressVersion != activeNet.ScriptHashAddrID {
+		return false
+	}
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	targetChecksum := checksum(append([]byte{addressVersion}, pubKeyHash...))
 
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
@@ -83,7 +123,9 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.Y.Bytes()...)
+	// x、y 补齐到曲线的字节长度，保证验证时可以从中间切分
+	keyLen := (curve.Params().BitSize + 7) / 8
+	pubKey := append(private.PublicKey.X.FillBytes(make([]byte, keyLen)), private.Y.FillBytes(make([]byte, keyLen))...)
 	return *private, pubKey
 }
 
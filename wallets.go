@@ -2,24 +2,84 @@ package main
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	walletFile        = "wallet.dat"
+	walletFileVersion = 3
+	hdGapLimit        = 20 // 恢复HD钱包时，连续未使用的地址达到该数量后停止派生
+
+	// scrypt 参数，由口令派生32字节的AES-256密钥
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
 )
 
-const walletFile = "wallet.dat"
+// walletCheckData 加密后保存在文件中，用于校验口令是否正确
+var walletCheckData = []byte("wallet passphrase check")
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+
+	encrypted     bool
+	salt          []byte
+	check         []byte            // 加密后的walletCheckData
+	encryptedKeys map[string][]byte // 地址 -> 加密后的私钥
+	key           []byte            // 解锁后由口令派生的密钥，锁定时为nil
+	hdSeed        []byte            // HD种子，加密的钱包锁定时为nil
+	encryptedSeed []byte            // 加密后的HD种子
+	hdAccount     *HDKey            // 账户扩展公钥，锁定时也可以派生地址
+	hdIndex       uint32            // 下一个派生地址的索引
+	hdAddresses   map[string]uint32 // HD地址 -> 派生索引
+	mu            sync.Mutex
+	lockTimer     *time.Timer
+}
+
+// walletFileData wallet.dat 的内容
+// 私钥只保存标量D，加密时D使用AES-256-GCM加密，公钥与地址始终以明文保存
+// HD钱包只保存种子、账户扩展公钥与派生索引，HD地址的密钥在加载时重新派生
+// Keys 中只有非HD的独立密钥
+type walletFileData struct {
+	Version          int
+	Encrypted        bool
+	Salt             []byte
+	Check            []byte
+	Keys             map[string]walletKey
+	Seed             []byte // 加密时为加密后的种子
+	AccountKey       []byte
+	AccountChainCode []byte
+	HDIndex          uint32
+}
+
+type walletKey struct {
+	PublicKey  []byte
+	PrivateKey []byte
 }
 
 // NewWallets 创建钱包并从文件中加载数据（如果存在）
 func NewWallets() (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.encryptedKeys = make(map[string][]byte)
+	wallets.hdAddresses = make(map[string]uint32)
 
 	err := wallets.LoadFromFile()
 
@@ -27,13 +87,179 @@ func NewWallets() (*Wallets, error) {
 }
 
 // CreateWallet 添加一个wallet到wallets
-func (ws *Wallets) CreateWallet() string {
+// HD钱包由种子派生下一个地址，否则生成独立的随机密钥
+// 加密的钱包需要先解锁
+func (ws *Wallets) CreateWallet() (string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encrypted && ws.key == nil {
+		return "", errors.New("Wallet is locked")
+	}
+
+	if ws.hdAccount != nil {
+		return ws.nextHDAddress()
+	}
+
 	wallet := NewWallet()
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 
+	if ws.encrypted {
+		encryptedKey, err := encryptWithKey(ws.key, privateKeyBytes(wallet.PrivateKey))
+		if err != nil {
+			return "", err
+		}
+		ws.encryptedKeys[address] = encryptedKey
+	}
 	ws.Wallets[address] = wallet
 
-	return address
+	return address, nil
+}
+
+// SetSeed 设置HD种子，此后CreateWallet由种子派生地址
+// 加密的钱包需要先解锁
+func (ws *Wallets) SetSeed(seed []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encrypted && ws.key == nil {
+		return errors.New("Wallet is locked")
+	}
+	if ws.hdAccount != nil {
+		return errors.New("Wallet already has an HD seed")
+	}
+
+	account, err := hdAccountKey(seed)
+	if err != nil {
+		return err
+	}
+
+	if ws.encrypted {
+		ws.encryptedSeed, err = encryptWithKey(ws.key, seed)
+		if err != nil {
+			return err
+		}
+	}
+	ws.hdSeed = seed
+	ws.hdAccount = account.Neuter()
+	ws.hdIndex = 0
+
+	return nil
+}
+
+// IsHD 钱包是否包含HD种子
+func (ws *Wallets) IsHD() bool {
+	return ws.hdAccount != nil
+}
+
+// ScanHDAddresses 恢复HD钱包时派生地址，直到连续hdGapLimit个地址未被使用
+// 派生索引设置为最后一个被使用的地址之后，至少保留一个地址
+func (ws *Wallets) ScanHDAddresses(isUsed func(address string) bool) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.hdAccount == nil {
+		return errors.New("Wallet has no HD seed")
+	}
+
+	used := uint32(0)
+	for unused := 0; unused < hdGapLimit; {
+		address, err := ws.nextHDAddress()
+		if err != nil {
+			return err
+		}
+
+		if isUsed(address) {
+			used = ws.hdIndex
+			unused = 0
+		} else {
+			unused++
+		}
+	}
+	if used == 0 {
+		used = 1
+	}
+
+	for address, index := range ws.hdAddresses {
+		if index >= used {
+			delete(ws.Wallets, address)
+			delete(ws.hdAddresses, address)
+		}
+	}
+	ws.hdIndex = used
+
+	return nil
+}
+
+// nextHDAddress 派生下一个HD地址并加入wallets
+func (ws *Wallets) nextHDAddress() (string, error) {
+	for {
+		index := ws.hdIndex
+		ws.hdIndex++
+
+		wallet, err := ws.deriveHDWallet(index)
+		if err == errInvalidChild {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		address := string(wallet.GetAddress())
+		ws.Wallets[address] = wallet
+		ws.hdAddresses[address] = index
+
+		return address, nil
+	}
+}
+
+// deriveHDWallet 派生第index个HD地址的钱包
+// 种子在内存中时包含私钥，否则只由账户扩展公钥派生公钥
+func (ws *Wallets) deriveHDWallet(index uint32) (*Wallet, error) {
+	account := ws.hdAccount
+	if ws.hdSeed != nil {
+		var err error
+		account, err = hdAccountKey(ws.hdSeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := account.Child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Wallet(), nil
+}
+
+// loadHDAddresses 重新派生所有HD地址
+func (ws *Wallets) loadHDAddresses() error {
+	for index := uint32(0); index < ws.hdIndex; index++ {
+		wallet, err := ws.deriveHDWallet(index)
+		if err == errInvalidChild {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		address := string(wallet.GetAddress())
+		ws.Wallets[address] = wallet
+		ws.hdAddresses[address] = index
+	}
+
+	return nil
+}
+
+// hdAccountKey 由种子派生账户扩展私钥
+func hdAccountKey(seed []byte) (*HDKey, error) {
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	return master.Derive(hdAccountPath())
 }
 
 // GetAddresses 返回wallets中所有wallet的地址
@@ -48,50 +274,350 @@ func (ws *Wallets) GetAddresses() []string {
 }
 
 // GetWallet 通过地址获取一个钱包
-func (ws Wallets) GetWallet(address string) Wallet {
+// 加密的钱包锁定时，返回的钱包不包含私钥
+func (ws *Wallets) GetWallet(address string) Wallet {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
 	return *ws.Wallets[address]
 }
 
+// IsEncrypted 钱包是否已加密
+func (ws *Wallets) IsEncrypted() bool {
+	return ws.encrypted
+}
+
+// IsLocked 钱包是否处于锁定状态，未加密的钱包始终是解锁的
+func (ws *Wallets) IsLocked() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	return ws.encrypted && ws.key == nil
+}
+
+// EncryptWallet 使用口令加密钱包，加密后钱包处于锁定状态
+func (ws *Wallets) EncryptWallet(passphrase string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.encrypted {
+		return errors.New("Wallet is already encrypted")
+	}
+	if passphrase == "" {
+		return errors.New("Passphrase is empty")
+	}
+
+	err := ws.setPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+	ws.encrypted = true
+	ws.lock()
+
+	return nil
+}
+
+// Unlock 使用口令解锁钱包，解锁后可以使用私钥签名
+// timeout 大于0时，超时后自动锁定
+func (ws *Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if !ws.encrypted {
+		return errors.New("Wallet is not encrypted")
+	}
+
+	key, err := ws.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	for address, encryptedKey := range ws.encryptedKeys {
+		d, err := decryptWithKey(key, encryptedKey)
+		if err != nil {
+			return err
+		}
+		ws.Wallets[address].PrivateKey = privateKeyFromBytes(d)
+	}
+	if ws.encryptedSeed != nil {
+		ws.hdSeed, err = decryptWithKey(key, ws.encryptedSeed)
+		if err != nil {
+			return err
+		}
+		err = ws.loadHDAddresses()
+		if err != nil {
+			return err
+		}
+	}
+	ws.key = key
+
+	if ws.lockTimer != nil {
+		ws.lockTimer.Stop()
+		ws.lockTimer = nil
+	}
+	if timeout > 0 {
+		ws.lockTimer = time.AfterFunc(timeout, ws.Lock)
+	}
+
+	return nil
+}
+
+// Lock 锁定钱包，清除内存中的私钥
+func (ws *Wallets) Lock() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.lock()
+}
+
+// ChangePassphrase 修改钱包口令
+func (ws *Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if !ws.IsEncrypted() {
+		return errors.New("Wallet is not encrypted")
+	}
+	if newPassphrase == "" {
+		return errors.New("Passphrase is empty")
+	}
+
+	err := ws.Unlock(oldPassphrase, 0)
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	err = ws.setPassphrase(newPassphrase)
+	ws.lock()
+
+	return err
+}
+
+// setPassphrase 使用新的盐与口令派生密钥，重新加密所有私钥与HD种子
+// 调用时私钥与种子必须在内存中
+func (ws *Wallets) setPassphrase(passphrase string) error {
+	salt := make([]byte, saltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return err
+	}
+
+	check, err := encryptWithKey(key, walletCheckData)
+	if err != nil {
+		return err
+	}
+
+	encryptedKeys := make(map[string][]byte)
+	for address, wallet := range ws.Wallets {
+		if _, ok := ws.hdAddresses[address]; ok {
+			continue
+		}
+		encryptedKeys[address], err = encryptWithKey(key, privateKeyBytes(wallet.PrivateKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	var encryptedSeed []byte
+	if ws.hdSeed != nil {
+		encryptedSeed, err = encryptWithKey(key, ws.hdSeed)
+		if err != nil {
+			return err
+		}
+	}
+
+	ws.salt = salt
+	ws.check = check
+	ws.encryptedKeys = encryptedKeys
+	ws.encryptedSeed = encryptedSeed
+
+	return nil
+}
+
+// deriveKey 由口令派生密钥并校验口令是否正确
+func (ws *Wallets) deriveKey(passphrase string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), ws.salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := decryptWithKey(key, ws.check)
+	if err != nil || !bytes.Equal(check, walletCheckData) {
+		return nil, errors.New("The wallet passphrase entered was incorrect")
+	}
+
+	return key, nil
+}
+
+// lock 清除内存中的私钥、HD种子与密钥
+func (ws *Wallets) lock() {
+	if !ws.encrypted {
+		return
+	}
+
+	for _, wallet := range ws.Wallets {
+		wallet.PrivateKey = ecdsa.PrivateKey{}
+	}
+	ws.hdSeed = nil
+	ws.key = nil
+}
+
 // LoadFromFile 从文件中加载钱包数据
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(dataFilePath(walletFile)); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(dataFilePath(walletFile))
 	if err != nil {
 		log.Panic(err)
 	}
 
-	var wallets Wallets
-	// Register 编解码的对象中的interface可能为elliptic.Curve
-	gob.Register(elliptic.P256())
+	var data walletFileData
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	err = decoder.Decode(&data)
 	if err != nil {
 		log.Panic(err)
 	}
+	// 版本2的文件没有HD种子，可以直接读取
+	if data.Version < 2 || data.Version > walletFileVersion {
+		log.Panicf("ERROR: Unsupported wallet file version %d", data.Version)
+	}
+
+	ws.encrypted = data.Encrypted
+	ws.salt = data.Salt
+	ws.check = data.Check
+
+	for address, key := range data.Keys {
+		wallet := &Wallet{PublicKey: key.PublicKey}
+		if data.Encrypted {
+			ws.encryptedKeys[address] = key.PrivateKey
+		} else {
+			wallet.PrivateKey = privateKeyFromBytes(key.PrivateKey)
+		}
+		ws.Wallets[address] = wallet
+	}
+
+	if data.AccountKey != nil {
+		ws.hdAccount = &HDKey{PublicKey: data.AccountKey, ChainCode: data.AccountChainCode}
+		ws.hdIndex = data.HDIndex
+		if data.Encrypted {
+			ws.encryptedSeed = data.Seed
+		} else {
+			ws.hdSeed = data.Seed
+		}
 
-	ws.Wallets = wallets.Wallets
+		return ws.loadHDAddresses()
+	}
 
 	return nil
 }
 
 // SaveToFile 将钱包数据保存到文件中
-func (ws Wallets) SaveToFile() {
+// 加密的钱包只保存加密后的私钥
+func (ws *Wallets) SaveToFile() {
 	var content bytes.Buffer
 
-	// Register 编解码的对象中的interface可能为elliptic.Curve
-	gob.Register(elliptic.P256())
+	ws.mu.Lock()
+	data := walletFileData{
+		Version:   walletFileVersion,
+		Encrypted: ws.encrypted,
+		Salt:      ws.salt,
+		Check:     ws.check,
+		Keys:      make(map[string]walletKey),
+	}
+	if ws.hdAccount != nil {
+		data.AccountKey = ws.hdAccount.PublicKey
+		data.AccountChainCode = ws.hdAccount.ChainCode
+		data.HDIndex = ws.hdIndex
+		if ws.encrypted {
+			data.Seed = ws.encryptedSeed
+		} else {
+			data.Seed = ws.hdSeed
+		}
+	}
+	for address, wallet := range ws.Wallets {
+		if _, ok := ws.hdAddresses[address]; ok {
+			continue
+		}
+		key := walletKey{PublicKey: wallet.PublicKey}
+		if ws.encrypted {
+			key.PrivateKey = ws.encryptedKeys[address]
+		} else {
+			key.PrivateKey = privateKeyBytes(wallet.PrivateKey)
+		}
+		data.Keys[address] = key
+	}
+	ws.mu.Unlock()
 
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	err := encoder.Encode(data)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	ensureDataDir()
+	err = ioutil.WriteFile(dataFilePath(walletFile), content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
 }
+
+// privateKeyBytes 返回私钥的标量D
+func privateKeyBytes(privKey ecdsa.PrivateKey) []byte {
+	return privKey.D.Bytes()
+}
+
+// privateKeyFromBytes 由标量D恢复P256私钥
+func privateKeyFromBytes(d []byte) ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	privKey := ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	privKey.PublicKey.Curve = curve
+	privKey.PublicKey.X, privKey.PublicKey.Y = curve.ScalarBaseMult(d)
+
+	return privKey
+}
+
+// encryptWithKey 使用AES-256-GCM加密，结果为 nonce + 密文
+func encryptWithKey(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decryptWithKey 解密encryptWithKey的结果
+func decryptWithKey(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("Ciphertext is too short")
+	}
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, sealed, nil)
+}